@@ -0,0 +1,843 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/idexter/monkey/ast"
+	"github.com/idexter/monkey/code"
+	"github.com/idexter/monkey/object"
+	"github.com/idexter/monkey/token"
+)
+
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
+}
+
+type CompilationScope struct {
+	instructions        code.Instructions
+	positions           map[int]token.Position
+	names               map[int]string // names of the variables read by instructions
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction
+	loops               []*loop // loops being compiled, the innermost last
+}
+
+// loop keeps track of jumps out of a loop being compiled.
+type loop struct {
+	continueTarget int      // where continue jumps to
+	breaks         []int    // positions of jumps made by break, patched at the end of the loop
+	scoped         []func() // restore the variables declared in the loop body
+}
+
+// Compiler lowers AST to bytecode executed by the vm package.
+type Compiler struct {
+	constants   []object.Object
+	symbolTable *SymbolTable
+
+	scopes     []CompilationScope
+	scopeIndex int
+
+	pos token.Position // position of the node being compiled
+	err error          // first operand which didn't fit in its instruction
+}
+
+var infixOperators = map[string]code.Opcode{
+	"+":  code.OpAdd,
+	"-":  code.OpSub,
+	"*":  code.OpMul,
+	"/":  code.OpDiv,
+	"%":  code.OpMod,
+	"**": code.OpPow,
+	"&":  code.OpBitAnd,
+	"|":  code.OpBitOr,
+	"^":  code.OpBitXor,
+	"<<": code.OpShiftLeft,
+	">>": code.OpShiftRight,
+	">":  code.OpGreaterThan,
+	"<":  code.OpLessThan,
+	">=": code.OpGreaterEqual,
+	"<=": code.OpLessEqual,
+	"==": code.OpEqual,
+	"!=": code.OpNotEqual,
+}
+
+func New() *Compiler {
+	mainScope := CompilationScope{
+		instructions:        code.Instructions{},
+		positions:           make(map[int]token.Position),
+		names:               make(map[int]string),
+		lastInstruction:     EmittedInstruction{},
+		previousInstruction: EmittedInstruction{},
+	}
+
+	symbolTable := NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+
+	return &Compiler{
+		constants:   []object.Object{},
+		symbolTable: symbolTable,
+		scopes:      []CompilationScope{mainScope},
+		scopeIndex:  0,
+	}
+}
+
+// NewWithState creates Compiler which keeps globals and constants
+// defined by previous compilations, e.g. in REPL.
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	compiler := New()
+	compiler.symbolTable = s
+	compiler.constants = constants
+	return compiler
+}
+
+func (c *Compiler) Compile(node ast.Node) error {
+	// Instructions are attributed to the innermost node they are emitted for.
+	pos := c.pos
+	c.pos = node.Pos()
+	defer func() { c.pos = pos }()
+
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+
+	case *ast.ExpressionStatement:
+		if err := c.Compile(node.Expression); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+
+	case *ast.LetStatement:
+		var symbol Symbol
+		var err error
+		fn, ok := node.Value.(*ast.FunctionLiteral)
+		if ok && fn.Name != "" {
+			// The function may assign to the variable it is bound to,
+			// so the variable has to exist while the function is compiled.
+			if symbol, err = c.declare(node, node.Name, node.IsConst()); err != nil {
+				return err
+			}
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		if !ok || fn.Name == "" {
+			if symbol, err = c.declare(node, node.Name, node.IsConst()); err != nil {
+				return err
+			}
+		}
+		c.defineSymbol(symbol)
+
+	case *ast.WhileStatement:
+		loopStart := len(c.currentInstructions())
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+		if err := c.compileLoopBody(node.Body, loopStart); err != nil {
+			return err
+		}
+		c.emit(code.OpJump, loopStart)
+
+		afterLoopPos := len(c.currentInstructions())
+		c.changeOperand(jumpNotTruthyPos, afterLoopPos)
+		c.patchBreaks(afterLoopPos)
+
+		// Loops are statements, but have the value null like if expressions
+		// without else do, to be the same in function bodies and REPL.
+		c.emit(code.OpNull)
+		c.emit(code.OpPop)
+
+	case *ast.ForStatement:
+		if err := c.Compile(node.Iterable); err != nil {
+			return err
+		}
+		c.emit(code.OpIterator)
+
+		loopStart := c.emit(code.OpIterNext, 9999)
+		if c.symbolTable.isConstant(node.Variable.Value) {
+			return fmt.Errorf("%s: cannot assign to constant: %s", node.Pos(), node.Variable.Value)
+		}
+		symbol, restore := c.symbolTable.defineIteration(node.Variable.Value, false)
+		c.defineSymbol(symbol)
+
+		if err := c.compileLoopBody(node.Body, loopStart); err != nil {
+			return err
+		}
+		c.emit(code.OpJump, loopStart)
+		restore()
+
+		// break leaves the iterator on the stack, unlike exhausting it.
+		c.patchBreaks(len(c.currentInstructions()))
+		c.emit(code.OpPop)
+
+		afterLoopPos := len(c.currentInstructions())
+		c.changeOperand(loopStart, afterLoopPos)
+
+		c.emit(code.OpNull)
+		c.emit(code.OpPop)
+
+	case *ast.BreakStatement:
+		l := c.currentLoop()
+		if l == nil {
+			return fmt.Errorf("%s: break outside of loop", node.Pos())
+		}
+		l.breaks = append(l.breaks, c.emit(code.OpJump, 9999))
+
+	case *ast.ContinueStatement:
+		l := c.currentLoop()
+		if l == nil {
+			return fmt.Errorf("%s: continue outside of loop", node.Pos())
+		}
+		c.emit(code.OpJump, l.continueTarget)
+
+	case *ast.ReturnStatement:
+		if err := c.Compile(node.ReturnValue); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(node.Value)
+		if !ok {
+			// A global may still be declared before the code runs, the
+			// same as in the evaluator, so the VM reports it if it isn't.
+			symbol = c.symbolTable.DefineUndeclared(node.Value)
+		}
+		c.loadSymbol(symbol)
+
+	case *ast.AssignExpression:
+		if err := c.compileAssignment(node); err != nil {
+			return err
+		}
+
+	case *ast.PrefixExpression:
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		switch node.Operator {
+		case "!":
+			c.emit(code.OpBang)
+		case "-":
+			c.emit(code.OpMinus)
+		default:
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
+		}
+
+	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogicalExpression(node)
+		}
+
+		op, ok := infixOperators[node.Operator]
+		if !ok {
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
+		}
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		c.emit(op)
+
+	case *ast.IfExpression:
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+
+		// Emit an `OpJumpNotTruthy` with a bogus value
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+		if err := c.compileBlockValue(node.Consequence); err != nil {
+			return err
+		}
+
+		// Emit an `OpJump` with a bogus value
+		jumpPos := c.emit(code.OpJump, 9999)
+
+		afterConsequencePos := len(c.currentInstructions())
+		c.changeOperand(jumpNotTruthyPos, afterConsequencePos)
+
+		if node.Alternative == nil {
+			c.emit(code.OpNull)
+		} else {
+			if err := c.compileBlockValue(node.Alternative); err != nil {
+				return err
+			}
+		}
+
+		afterAlternativePos := len(c.currentInstructions())
+		c.changeOperand(jumpPos, afterAlternativePos)
+
+	case *ast.IntegerLiteral:
+		var integer object.Object = &object.Integer{Value: node.Value}
+		if node.Big != nil {
+			integer = &object.BigInteger{Value: node.Big}
+		}
+		c.emit(code.OpConstant, c.addConstant(integer))
+
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(float))
+
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+
+	case *ast.StringLiteral:
+		str := &object.String{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(str))
+
+	case *ast.InterpolatedString:
+		for _, part := range node.Parts {
+			if err := c.Compile(part); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpInterpolate, len(node.Parts))
+
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			if err := c.Compile(el); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpArray, len(node.Elements))
+
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			if err := c.Compile(pair.Key); err != nil {
+				return err
+			}
+			if err := c.Compile(pair.Value); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpHash, len(node.Pairs)*2)
+
+	case *ast.SliceExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		for _, bound := range []ast.Expression{node.Low, node.High} {
+			if bound == nil {
+				c.emit(code.OpNull)
+			} else if err := c.Compile(bound); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpSlice)
+
+	case *ast.IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.emit(code.OpIndex)
+
+	case *ast.FunctionLiteral:
+		c.enterScope()
+
+		if node.Name != "" {
+			c.symbolTable.DefineFunctionName(node.Name)
+		}
+
+		for _, p := range node.Parameters {
+			c.symbolTable.Define(p.Value)
+		}
+
+		if err := c.Compile(node.Body); err != nil {
+			return err
+		}
+
+		if c.lastInstructionIs(code.OpPop) {
+			c.replaceLastPopWithReturn()
+		}
+		if !c.lastInstructionIs(code.OpReturnValue) {
+			c.emit(code.OpReturn)
+		}
+		c.markTailCalls()
+
+		freeSymbols := c.symbolTable.FreeSymbols
+		numLocals := c.symbolTable.numDefinitions
+		positions := c.scopes[c.scopeIndex].positions
+		names := c.scopes[c.scopeIndex].names
+		instructions := c.leaveScope()
+
+		for _, s := range freeSymbols {
+			c.captureSymbol(s)
+		}
+
+		compiledFn := &object.CompiledFunction{
+			Name:          node.Name,
+			Instructions:  instructions,
+			Positions:     positions,
+			Names:         names,
+			NumLocals:     numLocals,
+			NumParameters: len(node.Parameters),
+		}
+		c.emit(code.OpClosure, c.addConstant(compiledFn), len(freeSymbols))
+
+	case *ast.CallExpression:
+		if err := c.Compile(node.Function); err != nil {
+			return err
+		}
+		for _, a := range node.Arguments {
+			if err := c.Compile(a); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpCall, len(node.Arguments))
+
+	case *ast.BadStatement, *ast.BadExpression:
+		return fmt.Errorf("%s: syntax error", node.Pos())
+
+	default:
+		return fmt.Errorf("%s: unsupported node %T", node.Pos(), node)
+	}
+
+	return c.err
+}
+
+// declare defines the variable bound by the statement, which may not rebind
+// a constant of the same scope.
+func (c *Compiler) declare(stmt ast.Statement, name *ast.Identifier, constant bool) (Symbol, error) {
+	if c.symbolTable.isConstant(name.Value) {
+		return Symbol{}, fmt.Errorf("%s: cannot assign to constant: %s", stmt.Pos(), name.Value)
+	}
+	if l := c.currentLoop(); constant && l != nil {
+		// Each iteration has its own constants, which end with the loop.
+		symbol, restore := c.symbolTable.defineIteration(name.Value, true)
+		l.scoped = append(l.scoped, restore)
+		return symbol, nil
+	}
+	if constant {
+		return c.symbolTable.DefineConstant(name.Value), nil
+	}
+	return c.symbolTable.Define(name.Value), nil
+}
+
+// compileAssignment leaves the assigned value on the stack. The current value
+// for a compound operator is loaded before the right-hand side is evaluated,
+// the same as in the evaluator.
+func (c *Compiler) compileAssignment(node *ast.AssignExpression) error {
+	compound := node.Operator != "="
+	op, ok := infixOperators[strings.TrimSuffix(node.Operator, "=")]
+	if compound && !ok {
+		return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
+	}
+
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.ResolveAssignment(target.Value)
+		if !ok {
+			symbol = c.symbolTable.DefineUndeclared(target.Value)
+		}
+		if symbol.Scope == GlobalScope && c.symbolTable.isUndeclared(target.Value) {
+			// Reading the variable first makes the assignment fail unless
+			// the global is declared by the time it runs.
+			c.loadSymbol(symbol)
+			c.emit(code.OpPop)
+		}
+		if symbol.Scope == BuiltinScope {
+			return fmt.Errorf("%s: cannot assign to builtin: %s", target.Pos(), target.Value)
+		}
+		if symbol.Constant {
+			return fmt.Errorf("%s: cannot assign to constant: %s", target.Pos(), target.Value)
+		}
+
+		if compound {
+			c.loadSymbol(symbol)
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		if compound {
+			c.emit(op)
+		}
+		c.storeSymbol(symbol)
+		c.loadSymbol(symbol)
+
+	case *ast.IndexExpression:
+		if err := c.Compile(target.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(target.Index); err != nil {
+			return err
+		}
+		if compound {
+			c.emit(code.OpDup2)
+			c.emit(code.OpIndex)
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		if compound {
+			c.emit(op)
+		}
+		c.emit(code.OpSetIndex)
+
+	default:
+		return fmt.Errorf("%s: cannot assign to %s", node.Pos(), node.Target.String())
+	}
+
+	return nil
+}
+
+// compileLogicalExpression compiles && and || so the right operand is only
+// evaluated if the left one doesn't decide the result, which is a boolean.
+func (c *Compiler) compileLogicalExpression(node *ast.InfixExpression) error {
+	and := node.Operator == "&&"
+
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	// The left operand is truthy.
+	if and {
+		if err := c.compileBoolean(node.Right); err != nil {
+			return err
+		}
+	} else {
+		c.emit(code.OpTrue)
+	}
+	jumpPos := c.emit(code.OpJump, 9999)
+
+	// The left operand is falsy.
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+	if and {
+		c.emit(code.OpFalse)
+	} else if err := c.compileBoolean(node.Right); err != nil {
+		return err
+	}
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// compileBoolean leaves the truthiness of the expression on the stack.
+func (c *Compiler) compileBoolean(node ast.Expression) error {
+	if err := c.Compile(node); err != nil {
+		return err
+	}
+	c.emit(code.OpBang)
+	c.emit(code.OpBang)
+	return nil
+}
+
+// compileBlockValue compiles block of a conditional, so it leaves
+// the value of its last expression on the stack or null if there is none.
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	if err := c.Compile(block); err != nil {
+		return err
+	}
+	if c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+	return nil
+}
+
+// compileLoopBody compiles body of a loop which continues at continueTarget.
+func (c *Compiler) compileLoopBody(body *ast.BlockStatement, continueTarget int) error {
+	scope := &c.scopes[c.scopeIndex]
+	scope.loops = append(scope.loops, &loop{continueTarget: continueTarget})
+	return c.Compile(body)
+}
+
+// patchBreaks makes break statements of the innermost loop jump to target
+// and ends the loop.
+func (c *Compiler) patchBreaks(target int) {
+	scope := &c.scopes[c.scopeIndex]
+	l := scope.loops[len(scope.loops)-1]
+	for _, pos := range l.breaks {
+		c.changeOperand(pos, target)
+	}
+	for i := len(l.scoped) - 1; i >= 0; i-- {
+		l.scoped[i]()
+	}
+	scope.loops = scope.loops[:len(scope.loops)-1]
+}
+
+func (c *Compiler) currentLoop() *loop {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops)-1]
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		Instructions: c.currentInstructions(),
+		Positions:    c.scopes[c.scopeIndex].positions,
+		Names:        c.scopes[c.scopeIndex].names,
+		Constants:    c.constants,
+	}
+}
+
+// SymbolTable returns symbol table of the global scope.
+func (c *Compiler) SymbolTable() *SymbolTable {
+	return c.symbolTable
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	c.checkOperands(op, operands)
+	ins := code.Make(op, operands...)
+	pos := c.addInstruction(ins)
+	c.setLastInstruction(op, pos)
+	c.scopes[c.scopeIndex].positions[pos] = c.pos
+	return pos
+}
+
+// markTailCalls turns the calls of the function being compiled whose result
+// the function returns right away into tail calls.
+func (c *Compiler) markTailCalls() {
+	ins := c.currentInstructions()
+	for ip := 0; ip < len(ins); {
+		def, err := code.Lookup(ins[ip])
+		if err != nil {
+			return
+		}
+		_, read := code.ReadOperands(def, ins[ip+1:])
+		next := ip + 1 + read
+		if code.Opcode(ins[ip]) == code.OpCall && returnsAt(ins, next) {
+			ins[ip] = byte(code.OpTailCall)
+		}
+		ip = next
+	}
+}
+
+// returnsAt reports whether the instruction at ip, or the one it jumps to,
+// returns the value on top of the stack.
+func returnsAt(ins code.Instructions, ip int) bool {
+	// Jumps can't go round in circles without running other instructions,
+	// but the number of them is bounded all the same.
+	for jumps := 0; ip < len(ins) && jumps < len(ins); jumps++ {
+		switch code.Opcode(ins[ip]) {
+		case code.OpReturnValue:
+			return true
+		case code.OpJump:
+			ip = int(code.ReadUint16(ins[ip+1:]))
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// checkOperands records an error if an operand is too large for its
+// instruction, which would otherwise be truncated silently. Compile reports
+// the error once the node being compiled is done.
+func (c *Compiler) checkOperands(op code.Opcode, operands []int) {
+	def, err := code.Lookup(byte(op))
+	if err != nil || c.err != nil {
+		return
+	}
+	for i, operand := range operands {
+		max := 1<<(8*def.OperandWidths[i]) - 1
+		if operand > max {
+			c.err = fmt.Errorf("%s: %s", c.pos, operandLimit(def, op, i, max))
+			return
+		}
+	}
+}
+
+// operandLimit describes the limit on the i-th operand of op, which is max.
+func operandLimit(def *code.Definition, op code.Opcode, i, max int) string {
+	switch op {
+	case code.OpConstant:
+		return fmt.Sprintf("too many constants, at most %d are supported", max+1)
+	case code.OpClosure:
+		if i == 0 {
+			return fmt.Sprintf("too many constants, at most %d are supported", max+1)
+		}
+		return fmt.Sprintf("too many free variables in a function, at most %d are supported", max+1)
+	case code.OpGetGlobal, code.OpSetGlobal:
+		return fmt.Sprintf("too many global variables, at most %d are supported", max+1)
+	case code.OpGetLocal, code.OpSetLocal, code.OpCaptureLocal:
+		return fmt.Sprintf("too many local variables in a function, at most %d are supported", max+1)
+	case code.OpGetFree, code.OpSetFree, code.OpCaptureFree:
+		return fmt.Sprintf("too many free variables in a function, at most %d are supported", max+1)
+	case code.OpJump, code.OpJumpNotTruthy, code.OpIterNext:
+		return fmt.Sprintf("code too long, jumps reach at most %d bytes", max)
+	case code.OpCall:
+		return fmt.Sprintf("too many arguments, at most %d are supported", max)
+	case code.OpArray:
+		return fmt.Sprintf("too many elements in an array literal, at most %d are supported", max)
+	case code.OpHash:
+		return fmt.Sprintf("too many pairs in a hash literal, at most %d are supported", max/2)
+	case code.OpInterpolate:
+		return fmt.Sprintf("too many parts in an interpolated string, at most %d are supported", max)
+	default:
+		return fmt.Sprintf("operand %d of %s is too large, at most %d is supported", i, def.Name, max)
+	}
+}
+
+func (c *Compiler) addInstruction(ins []byte) int {
+	posNewInstruction := len(c.currentInstructions())
+	updatedInstructions := append(c.currentInstructions(), ins...)
+	c.scopes[c.scopeIndex].instructions = updatedInstructions
+	return posNewInstruction
+}
+
+func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	previous := c.scopes[c.scopeIndex].lastInstruction
+	last := EmittedInstruction{Opcode: op, Position: pos}
+
+	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].lastInstruction = last
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+func (c *Compiler) removeLastPop() {
+	last := c.scopes[c.scopeIndex].lastInstruction
+	previous := c.scopes[c.scopeIndex].previousInstruction
+
+	old := c.currentInstructions()
+	c.scopes[c.scopeIndex].instructions = old[:last.Position]
+	c.scopes[c.scopeIndex].lastInstruction = previous
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+	for i := 0; i < len(newInstruction); i++ {
+		ins[pos+i] = newInstruction[i]
+	}
+}
+
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	op := code.Opcode(c.currentInstructions()[opPos])
+	c.checkOperands(op, []int{operand})
+	newInstruction := code.Make(op, operand)
+	c.replaceInstruction(opPos, newInstruction)
+}
+
+func (c *Compiler) replaceLastPopWithReturn() {
+	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
+	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
+	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) enterScope() {
+	scope := CompilationScope{
+		instructions:        code.Instructions{},
+		positions:           make(map[int]token.Position),
+		names:               make(map[int]string),
+		lastInstruction:     EmittedInstruction{},
+		previousInstruction: EmittedInstruction{},
+	}
+	c.scopes = append(c.scopes, scope)
+	c.scopeIndex++
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() code.Instructions {
+	instructions := c.currentInstructions()
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+	c.symbolTable = c.symbolTable.Outer
+
+	return instructions
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	names := c.scopes[c.scopeIndex].names
+	switch s.Scope {
+	case GlobalScope:
+		names[c.emit(code.OpGetGlobal, s.Index)] = s.Name
+	case LocalScope:
+		names[c.emit(code.OpGetLocal, s.Index)] = s.Name
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+	case FreeScope:
+		names[c.emit(code.OpGetFree, s.Index)] = s.Name
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
+func (c *Compiler) storeSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpSetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, s.Index)
+	}
+}
+
+// defineSymbol stores the value of a variable being declared.
+func (c *Compiler) defineSymbol(s Symbol) {
+	switch {
+	case s.Iteration && s.Scope == GlobalScope:
+		c.emit(code.OpDefineGlobal, s.Index)
+	case s.Iteration && s.Scope == LocalScope:
+		c.emit(code.OpDefineLocal, s.Index)
+	default:
+		c.storeSymbol(s)
+	}
+}
+
+// captureSymbol pushes the variable a closure being created refers to,
+// so assignments made by the closure and outside of it are shared.
+func (c *Compiler) captureSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpCaptureGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpCaptureLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpCaptureFree, s.Index)
+	default:
+		c.loadSymbol(s)
+	}
+}
+
+// Bytecode is the result of compilation passed to the VM.
+type Bytecode struct {
+	Instructions code.Instructions
+	Positions    map[int]token.Position // source positions of the instructions
+	Names        map[int]string         // names of the variables the instructions read
+	Constants    []object.Object
+}
@@ -0,0 +1,189 @@
+package compiler
+
+type SymbolScope string
+
+const (
+	GlobalScope   SymbolScope = "GLOBAL"
+	LocalScope    SymbolScope = "LOCAL"
+	BuiltinScope  SymbolScope = "BUILTIN"
+	FreeScope     SymbolScope = "FREE"
+	FunctionScope SymbolScope = "FUNCTION"
+)
+
+type Symbol struct {
+	Name      string
+	Scope     SymbolScope
+	Index     int
+	Constant  bool // constants can't be assigned to
+	Iteration bool // each iteration of a loop binds the variable anew
+}
+
+// SymbolTable associates identifiers with the places their values are stored.
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	store          map[string]Symbol
+	numDefinitions int
+	undeclared     map[string]bool // globals used before they are declared
+
+	FreeSymbols []Symbol
+}
+
+func NewSymbolTable() *SymbolTable {
+	s := make(map[string]Symbol)
+	return &SymbolTable{store: s}
+}
+
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+func (s *SymbolTable) Define(name string) Symbol {
+	// Redefining a name in the same scope rebinds it, the same as in the
+	// evaluator. Code compiled before, like loop conditions and functions
+	// referring to the global, sees the new value.
+	if symbol, ok := s.store[name]; ok && (symbol.Scope == GlobalScope || symbol.Scope == LocalScope) {
+		delete(s.undeclared, name)
+		return symbol
+	}
+
+	symbol := Symbol{Name: name, Index: s.numDefinitions}
+	if s.Outer == nil {
+		symbol.Scope = GlobalScope
+	} else {
+		symbol.Scope = LocalScope
+	}
+
+	s.store[name] = symbol
+	s.numDefinitions++
+	return symbol
+}
+
+// defineScoped defines name in a new slot, for a variable which exists only
+// in a part of the scope, like the variable of a for-in loop. The variable
+// hides any other variable of the same name until restore is called.
+func (s *SymbolTable) defineScoped(name string) (symbol Symbol, restore func()) {
+	previous, ok := s.store[name]
+	delete(s.store, name)
+	symbol = s.Define(name)
+	return symbol, func() {
+		if ok {
+			s.store[name] = previous
+		} else {
+			delete(s.store, name)
+		}
+	}
+}
+
+// defineIteration defines name like defineScoped does, for a variable which
+// each iteration of a loop binds anew, like a constant in a loop body.
+func (s *SymbolTable) defineIteration(name string, constant bool) (symbol Symbol, restore func()) {
+	symbol, restore = s.defineScoped(name)
+	symbol.Constant = constant
+	symbol.Iteration = true
+	s.store[name] = symbol
+	return symbol, restore
+}
+
+// DefineUndeclared defines name, which isn't declared in any scope, as a
+// global, so that code can refer to a global declared after it. The variable
+// has no value until it's declared.
+func (s *SymbolTable) DefineUndeclared(name string) Symbol {
+	if s.Outer != nil {
+		return s.Outer.DefineUndeclared(name)
+	}
+	symbol := s.Define(name)
+	if s.undeclared == nil {
+		s.undeclared = make(map[string]bool)
+	}
+	s.undeclared[name] = true
+	return symbol
+}
+
+// isUndeclared reports whether name is a global defined by DefineUndeclared
+// which hasn't been declared since.
+func (s *SymbolTable) isUndeclared(name string) bool {
+	if s.Outer != nil {
+		return s.Outer.isUndeclared(name)
+	}
+	return s.undeclared[name]
+}
+
+// DefineConstant defines name like Define does, but as a constant.
+func (s *SymbolTable) DefineConstant(name string) Symbol {
+	symbol := s.Define(name)
+	symbol.Constant = true
+	s.store[name] = symbol
+	return symbol
+}
+
+// isConstant reports whether name is a constant defined in this scope.
+func (s *SymbolTable) isConstant(name string) bool {
+	symbol, ok := s.store[name]
+	return ok && symbol.Constant && (symbol.Scope == GlobalScope || symbol.Scope == LocalScope)
+}
+
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+// DefineFunctionName defines the name a function literal is bound to,
+// so the function can refer to itself.
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	obj, ok := s.store[name]
+	if !ok && s.Outer != nil {
+		obj, ok = s.Outer.Resolve(name)
+		if !ok {
+			return obj, ok
+		}
+
+		// Globals bound anew on each iteration of a loop are captured like
+		// locals, so that a closure sees the binding of its own iteration.
+		if obj.Scope == GlobalScope && !obj.Iteration || obj.Scope == BuiltinScope {
+			return obj, ok
+		}
+
+		free := s.defineFree(obj)
+		return free, true
+	}
+	return obj, ok
+}
+
+// ResolveAssignment resolves name as the target of an assignment. Unlike
+// Resolve, the name of the function being compiled refers to the variable the
+// function is bound to rather than to the function itself.
+func (s *SymbolTable) ResolveAssignment(name string) (Symbol, bool) {
+	obj, ok := s.store[name]
+	if ok && obj.Scope != FunctionScope {
+		return obj, true
+	}
+	if s.Outer == nil {
+		return obj, false
+	}
+
+	obj, ok = s.Outer.ResolveAssignment(name)
+	if !ok || obj.Scope == GlobalScope && !obj.Iteration || obj.Scope == BuiltinScope {
+		return obj, ok
+	}
+	return s.defineFree(obj), true
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1, Constant: original.Constant}
+	symbol.Scope = FreeScope
+
+	s.store[original.Name] = symbol
+	return symbol
+}
@@ -0,0 +1,92 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefine(t *testing.T) {
+	expected := map[string]Symbol{
+		"a": {Name: "a", Scope: GlobalScope, Index: 0},
+		"b": {Name: "b", Scope: GlobalScope, Index: 1},
+		"c": {Name: "c", Scope: LocalScope, Index: 0},
+		"d": {Name: "d", Scope: LocalScope, Index: 1},
+		"e": {Name: "e", Scope: LocalScope, Index: 0},
+		"f": {Name: "f", Scope: LocalScope, Index: 1},
+	}
+
+	global := NewSymbolTable()
+	assert.Equal(t, expected["a"], global.Define("a"))
+	assert.Equal(t, expected["b"], global.Define("b"))
+	assert.Equal(t, expected["a"], global.Define("a"), "redefinition must reuse the slot")
+
+	firstLocal := NewEnclosedSymbolTable(global)
+	assert.Equal(t, expected["c"], firstLocal.Define("c"))
+	assert.Equal(t, expected["d"], firstLocal.Define("d"))
+
+	secondLocal := NewEnclosedSymbolTable(firstLocal)
+	assert.Equal(t, expected["e"], secondLocal.Define("e"))
+	assert.Equal(t, expected["f"], secondLocal.Define("f"))
+}
+
+func TestDefineConstant(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	assert.Equal(t, Symbol{Name: "a", Scope: GlobalScope, Index: 0, Constant: true}, global.DefineConstant("a"))
+	assert.True(t, global.isConstant("a"))
+
+	local := NewEnclosedSymbolTable(global)
+	assert.False(t, local.isConstant("a"), "constants of outer scopes may be shadowed")
+
+	local.DefineConstant("b")
+	inner := NewEnclosedSymbolTable(local)
+	symbol, ok := inner.Resolve("b")
+	require.True(t, ok)
+	assert.Equal(t, Symbol{Name: "b", Scope: FreeScope, Index: 0, Constant: true}, symbol)
+}
+
+func TestResolveNestedLocal(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	global.DefineBuiltin(0, "len")
+
+	firstLocal := NewEnclosedSymbolTable(global)
+	firstLocal.Define("c")
+
+	secondLocal := NewEnclosedSymbolTable(firstLocal)
+	secondLocal.Define("e")
+
+	expected := []Symbol{
+		{Name: "a", Scope: GlobalScope, Index: 0},
+		{Name: "len", Scope: BuiltinScope, Index: 0},
+		{Name: "c", Scope: FreeScope, Index: 0},
+		{Name: "e", Scope: LocalScope, Index: 0},
+	}
+
+	for _, sym := range expected {
+		result, ok := secondLocal.Resolve(sym.Name)
+		require.True(t, ok, "name %s not resolvable", sym.Name)
+		assert.Equal(t, sym, result)
+	}
+
+	assert.Equal(t, []Symbol{{Name: "c", Scope: LocalScope, Index: 0}}, secondLocal.FreeSymbols)
+
+	_, ok := secondLocal.Resolve("unknown")
+	assert.False(t, ok, "unknown name resolved")
+}
+
+func TestDefineAndResolveFunctionName(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineFunctionName("a")
+
+	result, ok := global.Resolve("a")
+	require.True(t, ok, "function name a not resolvable")
+	assert.Equal(t, Symbol{Name: "a", Scope: FunctionScope, Index: 0}, result)
+
+	global.Define("a")
+	result, ok = global.Resolve("a")
+	require.True(t, ok, "name a not resolvable")
+	assert.Equal(t, Symbol{Name: "a", Scope: GlobalScope, Index: 0}, result)
+}
@@ -2,230 +2,241 @@ package evaluator
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/idexter/monkey/ast"
 	"github.com/idexter/monkey/object"
 )
 
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL     = &object.Null{}
+	TRUE     = object.TRUE
+	FALSE    = object.FALSE
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
-var builtins = map[string]*object.Builtin{
-	"len": {
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1", len(args))
-			}
-			switch arg := args[0].(type) {
-			case *object.Array:
-				return &object.Integer{Value: int64(len(arg.Elements))}
-			case *object.String:
-				return &object.Integer{Value: int64(len(arg.Value))}
-			default:
-				return newError("argument to `len` not supported, got %s", args[0].Type())
-			}
-		},
-	},
-	"first": {
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1",
-					len(args))
-			}
-			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `first` must be ARRAY, got %s", args[0].Type())
-			}
-			arr := args[0].(*object.Array)
-			if len(arr.Elements) > 0 {
-				return arr.Elements[0]
-			}
-			return NULL
-		},
-	},
-	"last": {
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1",
-					len(args))
-			}
-			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `last` must be ARRAY, got %s", args[0].Type())
-			}
-			arr := args[0].(*object.Array)
-			length := len(arr.Elements)
-			if length > 0 {
-				return arr.Elements[length-1]
-			}
-			return NULL
-		},
-	},
-	"rest": {
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1",
-					len(args))
-			}
-			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `rest` must be ARRAY, got %s", args[0].Type())
-			}
-			arr := args[0].(*object.Array)
-			length := len(arr.Elements)
-			if length > 0 {
-				newElements := make([]object.Object, length-1, length-1)
-				copy(newElements, arr.Elements[1:length])
-				return &object.Array{Elements: newElements}
-			}
-			return NULL
-		},
-	},
-	"push": {
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 2 {
-				return newError("wrong number of arguments. got=%d, want=2",
-					len(args))
-			}
-			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `push` must be ARRAY, got %s", args[0].Type())
-			}
-			arr := args[0].(*object.Array)
-			length := len(arr.Elements)
-			newElements := make([]object.Object, length+1, length+1)
-			copy(newElements, arr.Elements)
-			newElements[length] = args[1]
-			return &object.Array{Elements: newElements}
-		},
-	},
-	"puts": {
-		Fn: func(args ...object.Object) object.Object {
-			for _, arg := range args {
-				fmt.Println(arg.Inspect())
-			}
-			return NULL
-		},
-	},
+// DefaultMaxCallDepth is the maximum number of nested function calls of an
+// evaluator made by New.
+const DefaultMaxCallDepth = 10000
+
+// Evaluator evaluates programs by walking their AST.
+type Evaluator struct {
+	// CheckedArithmetic makes integer arithmetic report overflow of int64
+	// as an error instead of promoting the result to a big integer.
+	CheckedArithmetic bool
+	// MaxCallDepth is the maximum number of nested function calls. Calls
+	// in tail position don't nest, so they don't count towards the limit.
+	MaxCallDepth int
+}
+
+// New returns an evaluator which promotes integers on overflow and allows
+// DefaultMaxCallDepth nested calls.
+func New() *Evaluator {
+	return &Evaluator{MaxCallDepth: DefaultMaxCallDepth}
 }
 
+// Eval evaluates node with an evaluator made by New.
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	return New().Eval(node, env)
+}
+
+func (e *Evaluator) Eval(node ast.Node, env *object.Environment) object.Object {
+	result := e.eval(node, env)
+	// The innermost node which produced an error is the best guess
+	// about where it happened, so outer nodes keep the position.
+	if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = node.Pos()
+	}
+	return result
+}
+
+func (e *Evaluator) eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 
 	// Statements
 	case *ast.Program:
-		return evalProgram(node, env)
+		return e.evalProgram(node, env)
 
 	case *ast.ExpressionStatement:
-		return Eval(node.Expression, env)
+		return e.Eval(node.Expression, env)
 
 	// Expressions
 	case *ast.IntegerLiteral:
+		if node.Big != nil {
+			return &object.BigInteger{Value: node.Big}
+		}
 		return &object.Integer{Value: node.Value}
 
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
+
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 
 	case *ast.PrefixExpression:
-		right := Eval(node.Right, env)
-		if isError(right) {
+		right := e.Eval(node.Right, env)
+		if unwinds(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return e.evalPrefixExpression(node.Operator, right)
 
 	case *ast.InfixExpression:
-		left := Eval(node.Left, env)
-		if isError(left) {
+		if node.Operator == "&&" || node.Operator == "||" {
+			return e.evalLogicalExpression(node, env)
+		}
+
+		left := e.Eval(node.Left, env)
+		if unwinds(left) {
 			return left
 		}
 
-		right := Eval(node.Right, env)
-		if isError(right) {
+		right := e.Eval(node.Right, env)
+		if unwinds(right) {
 			return right
 		}
 
-		return evalInfixExpression(node.Operator, left, right)
+		return e.evalInfixExpression(node.Operator, left, right)
 
 	case *ast.BlockStatement:
-		return evalBlockStatement(node, env)
+		return e.evalBlockStatement(node, env)
 
 	case *ast.IfExpression:
-		return evalIfExpression(node, env)
+		return e.evalIfExpression(node, env)
 
 	case *ast.ReturnStatement:
-		val := Eval(node.ReturnValue, env)
-		if isError(val) {
+		// The returned expression is always in tail position, whatever
+		// block the return statement is nested in.
+		val := e.evalTail(node.ReturnValue, env)
+		if unwinds(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
 
 	case *ast.LetStatement:
-		val := Eval(node.Value, env)
-		if isError(val) {
+		val := e.Eval(node.Value, env)
+		if unwinds(val) {
 			return val
 		}
-		env.Set(node.Name.Value, val)
+		if node.IsConst() {
+			if err := env.SetConst(node.Name.Value, val); err != nil {
+				return err
+			}
+		} else if err := env.Set(node.Name.Value, val); err != nil {
+			return err
+		}
+
+	case *ast.WhileStatement:
+		return e.evalWhileStatement(node, env)
+
+	case *ast.ForStatement:
+		return e.evalForStatement(node, env)
+
+	case *ast.BreakStatement:
+		return BREAK
+
+	case *ast.ContinueStatement:
+		return CONTINUE
 
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
 
+	case *ast.AssignExpression:
+		return e.evalAssignExpression(node, env)
+
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
 		return &object.Function{
+			Name:       node.Name,
 			Parameters: params,
 			Body:       body,
 			Env:        env,
 		}
 
 	case *ast.CallExpression:
-		function := Eval(node.Function, env)
-		if isError(function) {
+		function := e.Eval(node.Function, env)
+		if unwinds(function) {
 			return function
 		}
-		args := evalExpressions(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
+		args := e.evalExpressions(node.Arguments, env)
+		if len(args) == 1 && unwinds(args[0]) {
 			return args[0]
 		}
 
-		return applyFunction(function, args)
+		result := e.applyFunction(function, args, node, env.Depth())
+		if err, ok := result.(*object.Error); ok {
+			addFrame(err, function, node)
+		}
+		return result
 
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 
+	case *ast.InterpolatedString:
+		parts := e.evalExpressions(node.Parts, env)
+		if len(parts) == 1 && unwinds(parts[0]) {
+			return parts[0]
+		}
+		return object.Interpolate(parts)
+
 	case *ast.ArrayLiteral:
-		elements := evalExpressions(node.Elements, env)
-		if len(elements) == 1 && isError(elements[0]) {
+		elements := e.evalExpressions(node.Elements, env)
+		if len(elements) == 1 && unwinds(elements[0]) {
 			return elements[0]
 		}
 		return &object.Array{Elements: elements}
 
 	case *ast.IndexExpression:
-		left := Eval(node.Left, env)
-		if isError(left) {
+		left := e.Eval(node.Left, env)
+		if unwinds(left) {
 			return left
 		}
-		index := Eval(node.Index, env)
-		if isError(index) {
+		index := e.Eval(node.Index, env)
+		if unwinds(index) {
 			return index
 		}
 		return evalIndexExpression(left, index)
 
+	case *ast.SliceExpression:
+		left := e.Eval(node.Left, env)
+		if unwinds(left) {
+			return left
+		}
+		bounds := []object.Object{NULL, NULL}
+		for i, bound := range []ast.Expression{node.Low, node.High} {
+			if bound != nil {
+				bounds[i] = e.Eval(bound, env)
+				if unwinds(bounds[i]) {
+					return bounds[i]
+				}
+			}
+		}
+		result, err := object.Slice(left, bounds[0], bounds[1])
+		if err != nil {
+			return err
+		}
+		return result
+
 	case *ast.HashLiteral:
-		return evalHashLiteral(node, env)
+		return e.evalHashLiteral(node, env)
+
+	case *ast.BadStatement, *ast.BadExpression:
+		return newError("syntax error")
 	}
 
 	return nil
 }
 
-func evalProgram(program *ast.Program, env *object.Environment) object.Object {
+func (e *Evaluator) evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	var result object.Object
 
 	for _, statement := range program.Statements {
-		result = Eval(statement, env)
+		result = e.Eval(statement, env)
 
 		switch result := result.(type) {
 		case *object.ReturnValue:
-			return result.Value
+			return e.trampoline(result.Value)
 		case *object.Error:
 			return result
 		}
@@ -234,23 +245,92 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	return result
 }
 
-func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
+func (e *Evaluator) evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
 	var result object.Object
 
 	for _, statement := range block.Statements {
-		result = Eval(statement, env)
+		result = e.Eval(statement, env)
 
-		if result != nil {
-			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
-				return result
-			}
+		if unwinds(result) {
+			return result
 		}
 	}
 
 	return result
 }
 
+// unwinds reports whether result of a statement or expression stops
+// evaluation of the block or expression it is in. A return nested in an
+// expression, e.g. in an if whose value is used, returns from the function
+// all the same.
+func unwinds(result object.Object) bool {
+	if result == nil {
+		return false
+	}
+	switch result.Type() {
+	case object.RETURN_VALUE_OBJ, object.ERROR_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+		return true
+	default:
+		return false
+	}
+}
+
+func (e *Evaluator) evalWhileStatement(ws *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		condition := e.Eval(ws.Condition, env)
+		if unwinds(condition) {
+			return condition
+		}
+		if !isThruty(condition) {
+			return NULL
+		}
+
+		loopEnv, err := object.NewLoopEnvironment(env, "")
+		if err != nil {
+			return err
+		}
+		result := e.Eval(ws.Body, loopEnv)
+		if result == BREAK {
+			return NULL
+		}
+		if result != CONTINUE && unwinds(result) {
+			return result
+		}
+	}
+}
+
+func (e *Evaluator) evalForStatement(fs *ast.ForStatement, env *object.Environment) object.Object {
+	iterable := e.Eval(fs.Iterable, env)
+	if unwinds(iterable) {
+		return iterable
+	}
+
+	values, ok := object.Iterate(iterable)
+	if !ok {
+		return newError("not iterable: %s", iterable.Type())
+	}
+
+	for _, value := range values {
+		loopEnv, err := object.NewLoopEnvironment(env, fs.Variable.Value)
+		if err != nil {
+			return err
+		}
+		if err := loopEnv.Set(fs.Variable.Value, value); err != nil {
+			return err
+		}
+
+		result := e.Eval(fs.Body, loopEnv)
+		if result == BREAK {
+			break
+		}
+		if result != CONTINUE && unwinds(result) {
+			return result
+		}
+	}
+
+	return NULL
+}
+
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	if input {
 		return TRUE
@@ -258,12 +338,12 @@ func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	return FALSE
 }
 
-func evalPrefixExpression(operator string, right object.Object) object.Object {
+func (e *Evaluator) evalPrefixExpression(operator string, right object.Object) object.Object {
 	switch operator {
 	case "!":
 		return evalBangOperatorExpression(right)
 	case "-":
-		return evalMinusPrefixOperatorExpression(right)
+		return e.evalMinusPrefixOperatorExpression(right)
 	default:
 		return newError("unknown operator: %s%s", operator, right.Type())
 	}
@@ -282,68 +362,119 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 	}
 }
 
-func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
+func (e *Evaluator) evalMinusPrefixOperatorExpression(right object.Object) object.Object {
+	if right.Type() == object.FLOAT_OBJ {
+		return &object.Float{Value: -right.(*object.Float).Value}
+	}
 	if right.Type() != object.INTEGER_OBJ {
 		return newError("unknown operator: -%s", right.Type())
 	}
 
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
+	result, err := object.NegateInteger(right, e.CheckedArithmetic)
+	if err != nil {
+		return err
+	}
+	return result
 }
 
-func evalInfixExpression(operator string, left, right object.Object) object.Object {
+func (e *Evaluator) evalInfixExpression(operator string, left, right object.Object) object.Object {
 	switch {
+	case operator == "==":
+		return nativeBoolToBooleanObject(object.Equal(left, right))
+	case operator == "!=":
+		return nativeBoolToBooleanObject(!object.Equal(left, right))
+	case left.Type() == object.FLOAT_OBJ && isNumber(right), right.Type() == object.FLOAT_OBJ && isNumber(left):
+		return evalFloatInfixExpression(operator, left, right)
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
-		return evalIntegerInfixExpression(operator, left, right)
+		return e.evalIntegerInfixExpression(operator, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left, right)
-	case operator == "==":
-		return nativeBoolToBooleanObject(left == right) // This works for booleans because of pointer comparison.
-	case operator == "!=":
-		return nativeBoolToBooleanObject(left != right) // This works for booleans because of pointer comparison.
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
-func evalIntegerInfixExpression(operator string, left, right object.Object) object.Object {
-	leftVal := left.(*object.Integer).Value
-	rightVal := right.(*object.Integer).Value
+// evalLogicalExpression evaluates && and || to a boolean. The right operand
+// is only evaluated if the left one doesn't decide the result.
+func (e *Evaluator) evalLogicalExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+	left := e.Eval(node.Left, env)
+	if unwinds(left) {
+		return left
+	}
+	if isThruty(left) == (node.Operator == "||") {
+		return nativeBoolToBooleanObject(isThruty(left))
+	}
+
+	right := e.Eval(node.Right, env)
+	if unwinds(right) {
+		return right
+	}
+	return nativeBoolToBooleanObject(isThruty(right))
+}
 
+func (e *Evaluator) evalIntegerInfixExpression(operator string, left, right object.Object) object.Object {
 	switch operator {
-	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
-	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
-	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
-	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+	case "+", "-", "*", "/", "%", "**", "&", "|", "^", "<<", ">>":
+		result, err := object.IntegerArithmetic(operator, left, right, e.CheckedArithmetic)
+		if err != nil {
+			return err
+		}
+		return result
+	case "<":
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) < 0)
+	case ">":
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) > 0)
+	case "<=":
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) <= 0)
+	case ">=":
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) >= 0)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+// evalFloatInfixExpression evaluates operators on two floats or a float and
+// an integer, which is converted to float.
+func evalFloatInfixExpression(operator string, left, right object.Object) object.Object {
+	leftVal, _ := object.ToFloat(left)
+	rightVal, _ := object.ToFloat(right)
+
+	switch operator {
+	case "+", "-", "*", "/", "%", "**":
+		result, err := object.FloatArithmetic(operator, leftVal, rightVal)
+		if err != nil {
+			return err
+		}
+		return &object.Float{Value: result}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
-	case "==":
-		return nativeBoolToBooleanObject(leftVal == rightVal)
-	case "!=":
-		return nativeBoolToBooleanObject(leftVal != rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
-func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
-	condition := Eval(ie.Condition, env)
-	if isError(condition) {
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+func (e *Evaluator) evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
+	condition := e.Eval(ie.Condition, env)
+	if unwinds(condition) {
 		return condition
 	}
 
 	if isThruty(condition) {
-		return Eval(ie.Consequence, env)
+		return e.Eval(ie.Consequence, env)
 	} else if ie.Alternative != nil {
-		return Eval(ie.Alternative, env)
+		return e.Eval(ie.Alternative, env)
 	} else {
 		return NULL
 	}
@@ -366,31 +497,94 @@ func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
-func isError(obj object.Object) bool {
-	if obj != nil {
-		return obj.Type() == object.ERROR_OBJ
-	}
-	return false
-}
-
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
 	if val, ok := env.Get(node.Value); ok {
 		return val
 	}
 
-	if builtin, ok := builtins[node.Value]; ok {
+	if builtin := object.GetBuiltinByName(node.Value); builtin != nil {
 		return builtin
 	}
 
 	return newError("identifier not found: " + node.Value)
 }
 
-func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
+// evalAssignExpression stores the value in the environment which defines the
+// variable, or in the element of the indexed collection. The current value for
+// a compound operator is read before the right-hand side is evaluated.
+func (e *Evaluator) evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		var current object.Object
+		if node.Operator != "=" {
+			current = evalIdentifier(target, env)
+			if unwinds(current) {
+				return current
+			}
+		}
+
+		val := e.evalAssignedValue(node, current, env)
+		if unwinds(val) {
+			return val
+		}
+
+		if _, ok := env.Get(target.Value); !ok && object.GetBuiltinByName(target.Value) != nil {
+			return newError("cannot assign to builtin: %s", target.Value)
+		}
+		if err := env.Assign(target.Value, val); err != nil {
+			return err
+		}
+		return val
+
+	case *ast.IndexExpression:
+		left := e.Eval(target.Left, env)
+		if unwinds(left) {
+			return left
+		}
+		index := e.Eval(target.Index, env)
+		if unwinds(index) {
+			return index
+		}
+
+		var current object.Object
+		if node.Operator != "=" {
+			current = evalIndexExpression(left, index)
+			if unwinds(current) {
+				return current
+			}
+		}
+
+		val := e.evalAssignedValue(node, current, env)
+		if unwinds(val) {
+			return val
+		}
+
+		if err := object.SetIndex(left, index, val); err != nil {
+			return err
+		}
+		return val
+
+	default:
+		return newError("cannot assign to %s", node.Target.String())
+	}
+}
+
+// evalAssignedValue evaluates the right-hand side of the assignment and
+// combines it with the current value of the target for compound operators.
+func (e *Evaluator) evalAssignedValue(node *ast.AssignExpression, current object.Object, env *object.Environment) object.Object {
+	val := e.Eval(node.Value, env)
+	if unwinds(val) || current == nil {
+		return val
+	}
+	return e.evalInfixExpression(strings.TrimSuffix(node.Operator, "="), current, val)
+}
+
+func (e *Evaluator) evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
 	var result []object.Object
 
-	for _, e := range exps {
-		evaluated := Eval(e, env)
-		if isError(evaluated) {
+	for _, exp := range exps {
+		evaluated := e.Eval(exp, env)
+		if unwinds(evaluated) {
 			return []object.Object{evaluated}
 		}
 		result = append(result, evaluated)
@@ -399,21 +593,189 @@ func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Ob
 	return result
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// applyFunction makes call of fn from a caller which is depth calls deep.
+func (e *Evaluator) applyFunction(fn object.Object, args []object.Object, call *ast.CallExpression, depth int) object.Object {
+	return e.trampoline(e.callFunction(fn, args, call, depth))
+}
+
+func (e *Evaluator) callFunction(fn object.Object, args []object.Object, call *ast.CallExpression, depth int) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
+		if len(args) != len(fn.Parameters) {
+			return newError("wrong number of arguments: want=%d, got=%d", len(fn.Parameters), len(args))
+		}
+		if depth >= e.MaxCallDepth {
+			return newError("maximum recursion depth exceeded calling %s", functionName(fn))
+		}
+		extendedEnv := extendFunctionEnv(fn, args, depth+1)
+		evaluated := e.evalTail(fn.Body, extendedEnv)
 		return unwrapReturnValue(evaluated)
 	case *object.Builtin:
-		return fn.Fn(args...)
+		if result := fn.Fn(e.callbackFunction(call, depth), args...); result != nil {
+			return result
+		}
+		return NULL
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
 }
 
-func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
-	env := object.NewEnclosedEnvironment(fn.Env)
+// callbackFunction returns the function a builtin made by call uses to call
+// functions passed to it. Those calls have no node of their own, so errors
+// point to the call of the builtin.
+func (e *Evaluator) callbackFunction(call *ast.CallExpression, depth int) object.CallFunction {
+	return func(fn object.Object, args ...object.Object) object.Object {
+		result := e.applyFunction(fn, args, call, depth)
+		if err, ok := result.(*object.Error); ok {
+			if !err.Pos.IsValid() {
+				err.Pos = call.Pos()
+			}
+			err.Stack = append(err.Stack, object.Frame{Function: functionName(fn), Pos: call.Pos()})
+		}
+		return result
+	}
+}
+
+// tailCall is a call in tail position which has not been made yet. It is
+// returned in place of the call's result, so the caller's Go frame is gone
+// by the time trampoline makes the call.
+type tailCall struct {
+	fn    object.Object
+	args  []object.Object
+	node  *ast.CallExpression
+	depth int
+}
+
+func (tc *tailCall) Type() object.Type { return "TAIL_CALL" }
+func (tc *tailCall) Inspect() string   { return tc.node.String() }
+
+// trampoline makes tail calls until one of them produces a value.
+func (e *Evaluator) trampoline(result object.Object) object.Object {
+	// Calls which replaced their caller, innermost last. A call that is
+	// already on the chain starts a new iteration of a loop, so the
+	// previous iteration is dropped and recursion doesn't grow the chain.
+	var chain []*tailCall
+
+	for {
+		call, ok := result.(*tailCall)
+		if !ok {
+			break
+		}
+		for i, c := range chain {
+			if c.node == call.node {
+				chain = chain[:i]
+				break
+			}
+		}
+		chain = append(chain, call)
+
+		result = e.callFunction(call.fn, call.args, call.node, call.depth)
+		if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() {
+			err.Pos = call.node.Pos()
+		}
+	}
+
+	if err, ok := result.(*object.Error); ok {
+		for i := len(chain) - 1; i >= 0; i-- {
+			addFrame(err, chain[i].fn, chain[i].node)
+		}
+	}
+	return result
+}
+
+// evalTail evaluates node in tail position of a function body. Calls are
+// not made but returned as tailCall, so they can be made by trampoline.
+func (e *Evaluator) evalTail(node ast.Node, env *object.Environment) object.Object {
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		var result object.Object
+
+		for i, statement := range node.Statements {
+			if i == len(node.Statements)-1 {
+				return e.evalTail(statement, env)
+			}
+			result = e.Eval(statement, env)
+
+			if unwinds(result) {
+				return result
+			}
+		}
+
+		return result
+
+	case *ast.ExpressionStatement:
+		return e.evalTail(node.Expression, env)
+
+	case *ast.IfExpression:
+		condition := e.Eval(node.Condition, env)
+		if unwinds(condition) {
+			return condition
+		}
+
+		if isThruty(condition) {
+			return e.evalTail(node.Consequence, env)
+		} else if node.Alternative != nil {
+			return e.evalTail(node.Alternative, env)
+		} else {
+			return NULL
+		}
+
+	case *ast.CallExpression:
+		function := e.Eval(node.Function, env)
+		if unwinds(function) {
+			return function
+		}
+		args := e.evalExpressions(node.Arguments, env)
+		if len(args) == 1 && unwinds(args[0]) {
+			return args[0]
+		}
+		// The call takes the place of the function whose body it ends,
+		// so it is made from that function's caller.
+		depth := env.Depth() - 1
+		if depth < 0 {
+			depth = 0 // return at the top level
+		}
+		return &tailCall{fn: function, args: args, node: node, depth: depth}
+	}
+
+	return e.Eval(node, env)
+}
+
+// addFrame records the call on the stack of the error it returned.
+func addFrame(err *object.Error, fn object.Object, call *ast.CallExpression) {
+	name := "<anonymous>"
+	switch fn := fn.(type) {
+	case *object.Function:
+		if fn.Name != "" {
+			name = fn.Name
+		} else if ident, ok := call.Function.(*ast.Identifier); ok {
+			name = ident.Value
+		}
+	case *object.Builtin:
+		if ident, ok := call.Function.(*ast.Identifier); ok {
+			name = ident.Value
+		}
+	default:
+		return
+	}
+	err.Stack = append(err.Stack, object.Frame{Function: name, Pos: call.Pos()})
+}
+
+// functionName names fn in stack traces of calls which have no node.
+func functionName(fn object.Object) string {
+	switch fn := fn.(type) {
+	case *object.Function:
+		if fn.Name != "" {
+			return fn.Name
+		}
+	case *object.Builtin:
+		return object.GetBuiltinName(fn)
+	}
+	return "<anonymous>"
+}
+
+func extendFunctionEnv(fn *object.Function, args []object.Object, depth int) *object.Environment {
+	env := object.NewCallEnvironment(fn.Env, depth)
 
 	for paramIdx, param := range fn.Parameters {
 		env.Set(param.Value, args[paramIdx])
@@ -429,20 +791,37 @@ func unwrapReturnValue(obj object.Object) object.Object {
 	return obj
 }
 
+// evalStringInfixExpression concatenates strings and compares them
+// lexicographically byte by byte.
 func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
-	if operator != "+" {
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
-	}
-
 	leftVal := left.(*object.String).Value
 	rightVal := right.(*object.String).Value
-	return &object.String{Value: leftVal + rightVal}
+
+	switch operator {
+	case "+":
+		return &object.String{Value: leftVal + rightVal}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
 }
 
 func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		if char, ok := object.StringIndex(left.(*object.String), index); ok {
+			return char
+		}
+		return NULL
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
 	default:
@@ -452,7 +831,11 @@ func evalIndexExpression(left, index object.Object) object.Object {
 
 func evalArrayIndexExpression(array, index object.Object) object.Object {
 	arrayObject := array.(*object.Array)
-	idx := index.(*object.Integer).Value
+	i, ok := index.(*object.Integer)
+	if !ok {
+		return NULL // a big integer is out of range of any array
+	}
+	idx := i.Value
 	max := int64(len(arrayObject.Elements) - 1)
 	if idx < 0 || idx > max {
 		return NULL
@@ -460,42 +843,34 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 	return arrayObject.Elements[idx]
 }
 
-func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
-	for keyNode, valueNode := range node.Pairs {
-		key := Eval(keyNode, env)
-		if isError(key) {
+func (e *Evaluator) evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
+	hash := &object.Hash{}
+	for _, pair := range node.Pairs {
+		key := e.Eval(pair.Key, env)
+		if unwinds(key) {
 			return key
 		}
 
-		hashKey, ok := key.(object.Hashable)
-		if !ok {
-			return newError("unusable as hash key: %s", key.Type())
-		}
-
-		value := Eval(valueNode, env)
-		if isError(value) {
+		value := e.Eval(pair.Value, env)
+		if unwinds(value) {
 			return value
 		}
 
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{Key: key, Value: value}
+		if err := hash.Set(key, value); err != nil {
+			return err
+		}
 	}
 
-	return &object.Hash{Pairs: pairs}
+	return hash
 }
 
 func evalHashIndexExpression(hash, index object.Object) object.Object {
-	hashObject := hash.(*object.Hash)
-
-	key, ok := index.(object.Hashable)
-	if !ok {
-		return newError("unusable as hash key: %s", index.Type())
+	value, ok, err := hash.(*object.Hash).Get(index)
+	if err != nil {
+		return err
 	}
-
-	pair, ok := hashObject.Pairs[key.HashKey()]
 	if !ok {
 		return NULL
 	}
-	return pair.Value
+	return value
 }
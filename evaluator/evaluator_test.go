@@ -19,6 +19,10 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"10", 10},
 		{"-5", -5},
 		{"-10", -10},
+		{"0xFF + 0o17 + 0b1010", 280},
+		{"1_000_000 / 1_000", 1000},
+		{"-0x10", -16},
+		{"012 + 1", 11},
 		{"5 + 5 + 5 + 5 - 10", 10},
 		{"2 * 2 * 2 * 2 * 2", 32},
 		{"-50 + 100 + -50", 0},
@@ -30,6 +34,17 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"3 * 3 * 3 + 10", 37},
 		{"3 * (3 * 3) + 10", 37},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"17 % 5", 2},
+		{"-17 % 5", -2},
+		{"2 ** 10", 1024},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"12 & 10", 8},
+		{"12 | 10", 14},
+		{"12 ^ 10", 6},
+		{"1 << 10", 1024},
+		{"-1024 >> 3", -128},
+		{"1 + 2 << 3", 17},
 	}
 
 	for _, tt := range tests {
@@ -39,12 +54,16 @@ func TestEvalIntegerExpression(t *testing.T) {
 }
 
 func testEval(input string) object.Object {
+	return testEvalWith(New(), input)
+}
+
+func testEvalWith(e *Evaluator, input string) object.Object {
 	l := lexer.New(input)
 	p := parser.New(l)
 	program := p.ParseProgram()
 	env := object.NewEnvironment()
 
-	return Eval(program, env)
+	return e.Eval(program, env)
 }
 
 func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
@@ -55,6 +74,39 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	return assert.Equal(t, expected, result.Value)
 }
 
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"2.5", 2.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2},
+		{"3 / 2.0", 1.5},
+		{"10 - 2.5e1", -15},
+		{"(1 + 2) * 0.5", 1.5},
+		{"7.5 % 2", 1.5},
+		{"2 ** 0.5 ** 2", 1.189207115002721},
+		{"4 ** -1.0", 0.25},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testFloatObject(t, evaluated, tt.expected)
+	}
+}
+
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	result, ok := obj.(*object.Float)
+	assert.True(t, ok, "object is not Float. got=%T (%+v)", obj, obj)
+	if !ok {
+		return false
+	}
+	return assert.Equal(t, expected, result.Value, "object has wrong value.")
+}
+
 func TestEvalBooleanExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -79,6 +131,45 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{"1 == 1.0", true},
+		{"1.5 != 1.5", false},
+		{"1 < 1.5", true},
+		{"2.5 > 3", false},
+		{"1 <= 1", true},
+		{"2 <= 1", false},
+		{"1 >= 1.5", false},
+		{"2.5 >= 2.5", true},
+		{`"a" < "b"`, true},
+		{`"abc" > "abd"`, false},
+		{`"ab" <= "abc"`, true},
+		{`"b" >= "abc"`, true},
+		{`"mon" + "key" == "monkey"`, true},
+		{`"a" != "a"`, false},
+		{"[1, 2] == [1, 2]", true},
+		{"[1, [2]] == [1, [2.0]]", true},
+		{"[1, 2] != [2, 1]", true},
+		{`{"a": [1], 2: "b"} == {2: "b", "a": [1]}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{"let a = [1]; let b = [1]; a[0] = a; b[0] = b; a == b", true},
+		{`let a = {"x": 1}; let b = {"x": 2}; a["self"] = a; b["self"] = b; a == b`, false},
+		{"if (false) { 1 } == if (false) { 2 }", true},
+		{"1 == if (false) { 1 }", false},
+		{`1 == "1"`, false},
+		{`1 != "1"`, true},
+		{"let f = fn() { 1 }; f == f", true},
+		{"fn() { 1 } == fn() { 1 }", false},
+		{`{1: "one"}[1.0] == "one"`, true},
+		{"true && true", true},
+		{"true && false", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 && \"a\"", true},
+		{"0 || if (false) { 1 }", true},
+		{"if (false) { 1 } || false", false},
+		{"1 < 2 && 2 < 3", true},
+		{"false && undefined", false},
+		{"true || undefined", true},
+		{"let called = false; let f = fn() { called = true }; false && f(); called", false},
 	}
 
 	for _, tt := range tests {
@@ -162,6 +253,9 @@ func TestReturnStatements(t *testing.T) {
 				}
 			return 1; }
 		`, 10},
+		{"let f = fn() { let x = if (true) { return 7 }; 5 }; f()", 7},
+		{"let f = fn() { puts(if (true) { return 7 }); 5 }; f()", 7},
+		{"let f = fn() { while (if (true) { return 7 }) { } 5 }; f()", 7},
 	}
 
 	for _, tt := range tests {
@@ -212,10 +306,20 @@ func TestErrorHandling(t *testing.T) {
 			`"Hello" - "World"`,
 			"unknown operator: STRING - STRING",
 		},
+		{"1 % 0", "division by zero"},
+		{"2 ** -1", "negative exponent: -1"},
+		{"1 << -1", "negative shift count: -1"},
+		{"1.5 & 1", "unknown operator: FLOAT & INTEGER"},
+		{"true <= false", "unknown operator: BOOLEAN <= BOOLEAN"},
 		{
 			`{"name": "Monkey"}[fn(x) { x }];`,
 			"unusable as hash key: FUNCTION",
 		},
+		{"1 / 0", "division by zero"},
+		{"1.5 / 0", "division by zero"},
+		{"-[1.5]", "unknown operator: -ARRAY"},
+		{"1.5 + true", "type mismatch: FLOAT + BOOLEAN"},
+		{"let f = fn(x) { 10 / x }; f(0)", "division by zero"},
 	}
 
 	for _, tt := range tests {
@@ -230,6 +334,88 @@ func TestErrorHandling(t *testing.T) {
 	}
 }
 
+func TestBigIntegers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775807 - 2", "-9223372036854775809"},
+		{"(-9223372036854775807 - 1) / -1", "9223372036854775808"},
+		{"-(-9223372036854775807 - 1)", "9223372036854775808"},
+		{"99999999999999999999", "99999999999999999999"},
+		{"99999999999999999999 - 99999999999999999998", "1"},
+		{"99999999999999999999 / 3", "33333333333333333333"},
+		{"-99999999999999999999 * 99999999999999999999", "-9999999999999999999800000000000000000001"},
+		{`
+		let factorial = fn(n) { if (n == 0) { 1 } else { n * factorial(n - 1) } };
+		factorial(50)`, "30414093201713378043612608166064768844377641568960512000000000000"},
+		{"int(1e19)", "10000000000000000000"},
+		{`int("123456789012345678901234567890")`, "123456789012345678901234567890"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		assert.Equal(t, object.INTEGER_OBJ, string(evaluated.Type()), tt.input)
+		assert.Equal(t, tt.expected, evaluated.Inspect(), tt.input)
+	}
+
+	// Results which fit in int64 are plain integers again.
+	testIntegerObject(t, testEval("9223372036854775808 - 1"), 9223372036854775807)
+
+	booleans := []struct {
+		input    string
+		expected bool
+	}{
+		{"9223372036854775808 == 9223372036854775807 + 1", true},
+		{"9223372036854775808 != 9223372036854775808", false},
+		{"9223372036854775808 > 1", true},
+		{"-9223372036854775809 < -9223372036854775808", true},
+		{"9223372036854775808 == 9223372036854775808.0", true},
+	}
+	for _, tt := range booleans {
+		testBooleanObject(t, testEval(tt.input), tt.expected)
+	}
+
+	evaluated := testEval(`{9223372036854775808: "big", 1: "small"}[9223372036854775807 + 1]`)
+	assert.Equal(t, "big", evaluated.Inspect())
+}
+
+func TestCheckedArithmetic(t *testing.T) {
+	tests := []struct {
+		input           string
+		expected        string
+		expectedMessage string
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808", "integer overflow: 9223372036854775807 + 1"},
+		{"-9223372036854775807 - 2", "-9223372036854775809", "integer overflow: -9223372036854775807 - 2"},
+		{"4611686018427387904 * 2", "9223372036854775808", "integer overflow: 4611686018427387904 * 2"},
+		{"-(-9223372036854775807 - 1)", "9223372036854775808", "integer overflow: -(-9223372036854775808)"},
+		{"(-9223372036854775807 - 1) / -1", "9223372036854775808", "integer overflow: -9223372036854775808 / -1"},
+		{"9223372036854775806 + 1", "9223372036854775807", ""},
+		{"-4611686018427387904 * 2", "-9223372036854775808", ""},
+	}
+
+	checked := New()
+	checked.CheckedArithmetic = true
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, testEval(tt.input).Inspect())
+
+		evaluated := testEvalWith(checked, tt.input)
+		if tt.expectedMessage == "" {
+			assert.Equal(t, tt.expected, evaluated.Inspect())
+			continue
+		}
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+		assert.Equal(t, tt.expectedMessage, errObj.Message)
+	}
+}
+
 func TestLetStatements(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -246,6 +432,111 @@ func TestLetStatements(t *testing.T) {
 	}
 }
 
+func TestAssignments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let x = 1; x = 5; x", 5},
+		{"let x = 1; x += 4; x *= 3; x -= 1; x /= 2; x", 7},
+		{"let x = 0; let y = 0; x = y = 3; x + y", 6},
+		{"let x = 1; let f = fn() { x = 10; }; f(); x", 10},
+		{"let f = fn() { let x = 1; let g = fn() { x += 1 }; g(); g(); x }; f()", 3},
+		{"let counter = fn() { let c = 0; fn() { c += 1 } }; let next = counter(); next(); next()", 2},
+		{"let a = [1, 2, 3]; a[1] = 20; a[2] *= 5; a[0] + a[1] + a[2]", 36},
+		{`let h = {"a": 1}; h["a"] += 1; h["b"] = 10; h["a"] + h["b"]`, 12},
+		{"let a = [1]; let b = a; b[0] = 2; a[0]", 2},
+		{"x = 1", "identifier not found: x"},
+		{"len = 1", "cannot assign to builtin: len"},
+		{"let a = [1]; a[1] = 2", "index out of range: 1"},
+		{`let a = [1]; a["x"] = 2`, "array index must be INTEGER, got STRING"},
+		{"let h = {}; h[fn(x) { x }] = 1", "unusable as hash key: FUNCTION"},
+		{`let s = "a"; s[0] = "b"`, "index assignment not supported: STRING"},
+		{`let x = 1; x += "a"`, "type mismatch: INTEGER + STRING"},
+		{"const x = 1; x = 2", "cannot assign to constant: x"},
+		{"const x = 1; let f = fn() { x += 1 }; f()", "cannot assign to constant: x"},
+		{"const x = 1; let x = 2", "cannot assign to constant: x"},
+		{"const x = 1; for (x in [1]) {}", "cannot assign to constant: x"},
+		{"let i = 0; while (i < 2) { const y = i; i += 1; } i", 2},
+		{"for (x in [1, 2]) { const y = x; } y", "identifier not found: y"},
+		{"const y = 1; for (x in [1, 2]) { const y = x; }", "cannot assign to constant: y"},
+		{"let y = 1; for (x in [1, 2]) { const y = x; } y", 1},
+		{"const x = 1; let f = fn() { let x = 2; x }; f() + x", 3},
+		{"const a = [1]; a[0] = 2; a[0]", 2},
+		{"let a = freeze([[1]]); a[0][0] = 2", "cannot modify frozen ARRAY"},
+		{`let h = freeze({"a": 1}); h["a"] = 2`, "cannot modify frozen HASH"},
+		{"let a = [1]; let b = freeze(a); a[0] = 2; b[0]", 1},
+		{"let h = {}; h[[fn(x) { x }]] = 1", "unusable as hash key: FUNCTION"},
+		{"let a = [1]; a[0] = a; let h = {}; h[a] = 1", "unusable as hash key: ARRAY"},
+		{"let a = [1]; a[0] = a; freeze(a)", "cannot freeze cyclic value: ARRAY"},
+		{`let h = {}; h["h"] = [h]; freeze(h)`, "cannot freeze cyclic value: HASH"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			require.True(t, ok, "no error object returned for %q. got=%T(%+v)", tt.input, evaluated, evaluated)
+			assert.Equal(t, expected, errObj.Message)
+		}
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let i = 0; while (i < 5) { let i = i + 1; } i", 5},
+		{"let i = 0; while (true) { let i = i + 1; if (i == 3) { break; } } i", 3},
+		{"let s = 0; for (x in [1, 2, 3, 4]) { if (x == 2) { continue; } let s = s + x; } s", 8},
+		{"let n = 0; for (k in {\"a\": 1, \"b\": 2}) { let n = n + 1; } n", 2},
+		{"let n = 0; for (c in \"abc\") { let n = n + 1; } n", 3},
+		{"let f = fn() { for (x in [1, 2, 3]) { if (x == 2) { return x * 10; } } }; f()", 20},
+		{"while (false) { 1 }", nil},
+		{"for (x in 5) { x }", "not iterable: INTEGER"},
+		{"for (x in [1, 2]) { } x", "identifier not found: x"},
+		{"let x = 5; for (x in [1, 2]) { } x", 5},
+		{"let x = 5; for (x in [1, 2]) { x += 10; } x", 5},
+		{"let fs = []; for (x in [1, 2]) { let fs = push(fs, fn() { x }); } fs[0]()", 1},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			require.True(t, ok, "no error object returned. got=%T(%+v)", evaluated, evaluated)
+			assert.Equal(t, expected, errObj.Message)
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestLoopClosures(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let i = 0; let r = []; while (i < 3) { const c = i; r = push(r, fn() { c }); i += 1 }; map(r, fn(f) { f() })", "[0, 1, 2]"},
+		{"let r = []; for (x in [1, 2, 3]) { r = push(r, fn() { x }); }; map(r, fn(f) { f() })", "[1, 2, 3]"},
+		{"let r = []; for (x in [1, 2]) { const c = x * 10; r = push(r, fn() { [x, c] }); }; map(r, fn(f) { f() })", "[[1, 10], [2, 20]]"},
+		{"let r = []; for (x in [1, 2]) { r = push(r, fn() { x }); x += 5; }; map(r, fn(f) { f() })", "[6, 7]"},
+		{"let r = []; let f = fn() { for (x in [1, 2]) { const c = x; r = push(r, fn() { c }); } }; f(); map(r, fn(g) { g() })", "[1, 2]"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		assert.Equal(t, tt.expected, evaluated.Inspect(), "wrong result of %s", tt.input)
+	}
+}
+
 func TestFunctionObject(t *testing.T) {
 	input := "fn(x) { x + 2 };"
 	evaluated := testEval(input)
@@ -291,6 +582,71 @@ func TestClosures(t *testing.T) {
 	testIntegerObject(t, testEval(input), 4)
 }
 
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{`
+		let countDown = fn(n) { if (n == 0) { 0 } else { countDown(n - 1) } };
+		countDown(1000000);`, 0},
+		{`
+		let sum = fn(n, acc) {
+			if (n == 0) { return acc; }
+			return sum(n - 1, acc + n);
+		};
+		sum(100000, 0);`, 5000050000},
+		{`
+		let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+		let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+		if (isEven(100000)) { 1 } else { 0 }`, 1},
+		{"let f = fn(x) { x * 2 }; let g = fn(x) { let y = x + 1; f(y) }; g(4)", 10},
+		{"let f = fn(x) { x }; return f(3);", 3},
+		// Returns in an if whose value is used return from the function, so
+		// the call they return is made instead of being stored as a value.
+		{"let g = fn() { 1 }; let f = fn() { let x = if (true) { return g(); }; puts(x); 5 }; f()", 1},
+		{"let g = fn() { 1 }; [if (true) { return g() }]", 1},
+		{`let g = fn() { 2 }; let f = fn() { {"k": 1 + if (true) { return g() }} }; f()`, 2},
+		{"let g = fn(x) { x }; let f = fn() { let a = [0]; a[0] = if (true) { return g(3) }; a }; f()", 3},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestTailCallErrorStackTrace(t *testing.T) {
+	input := `let countDown = fn(n) {
+  if (n == 0) { n + true } else { countDown(n - 1) }
+};
+countDown(100000);`
+
+	evaluated := testEval(input)
+	errObj, ok := evaluated.(*object.Error)
+	require.True(t, ok, "no error object returned. got=%T(%+v)", evaluated, evaluated)
+
+	assert.Equal(t, "ERROR: 2:17: type mismatch: INTEGER + BOOLEAN", errObj.Inspect())
+	assert.Equal(t, "\tin countDown called at 2:35\n\tin countDown called at 4:1\n", errObj.StackTrace())
+}
+
+func TestMaxCallDepth(t *testing.T) {
+	e := &Evaluator{MaxCallDepth: 100}
+
+	input := `let sum = fn(n) { if (n == 0) { 0 } else { n + sum(n - 1) } };`
+	testIntegerObject(t, testEvalWith(e, input+"sum(99)"), 4950)
+
+	evaluated := testEvalWith(e, input+"sum(100)")
+	errObj, ok := evaluated.(*object.Error)
+	require.True(t, ok, "no error object returned. got=%T(%+v)", evaluated, evaluated)
+	assert.Equal(t, "maximum recursion depth exceeded calling sum", errObj.Message)
+	require.Len(t, errObj.Stack, 101)
+	assert.Equal(t, "sum called at 1:48", errObj.Stack[0].String())
+
+	// Tail calls don't nest, so they are not limited.
+	input = `let countDown = fn(n) { if (n == 0) { 0 } else { countDown(n - 1) } }; countDown(1000)`
+	testIntegerObject(t, testEvalWith(e, input), 0)
+}
+
 func TestStringLiteral(t *testing.T) {
 	input := `"Hello world!"`
 	evaluated := testEval(input)
@@ -303,6 +659,69 @@ func TestStringLiteral(t *testing.T) {
 	}
 }
 
+func TestStringEscapes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"a\tb\nc"`, "a\tb\nc"},
+		{`"say \"hi\""`, `say "hi"`},
+		{`"C:\\dir"`, `C:\dir`},
+		{`"\u{e9}t\u{e9}"`, "été"},
+		{`len("\u{1F600}")`, "1"},
+		{"`raw \\n`", `raw \n`},
+		{"`line 1\nline 2`", "line 1\nline 2"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		assert.Equal(t, tt.expected, evaluated.Inspect(), "wrong result of %s", tt.input)
+	}
+}
+
+func TestInterpolatedStrings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let a = 1; let b = 2.5; "total: ${a + b}"`, "total: 3.5"},
+		{`let name = "Bob"; "${name}, ${name}!"`, "Bob, Bob!"},
+		{`"${[1, "x"]} ${{"k": true}}"`, "[1, x] {k: true}"},
+		{`"${if (false) { 1 }}"`, "null"},
+		{`"outer ${"inner ${1 + 1}"}"`, "outer inner 2"},
+		{`"\${a}"`, "${a}"},
+		{`len("${"é"}${10}")`, "3"},
+		{`"a ${1 + "b"} c"`, "type mismatch: INTEGER + STRING"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if err, ok := evaluated.(*object.Error); ok {
+			assert.Equal(t, tt.expected, err.Message, "wrong error of %s", tt.input)
+			continue
+		}
+		assert.Equal(t, tt.expected, evaluated.Inspect(), "wrong result of %s", tt.input)
+	}
+}
+
+func TestInspectCyclic(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let a = [1]; a[0] = a; a", "[[...]]"},
+		{`let h = {}; h["s"] = h; h`, "{s: {...}}"},
+		{`let a = [1]; let h = {"a": a}; a[0] = h; [a, h]`, "[[{a: [...]}], {a: [{...}]}]"},
+		{"let a = [1]; a[0] = a; [a, a]", "[[[...]], [[...]]]"},
+		{`let a = [1]; a[0] = a; "${a}"`, "[[...]]"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		assert.Equal(t, tt.expected, evaluated.Inspect(), "wrong result of %s", tt.input)
+	}
+}
+
 func TestStringConcatenation(t *testing.T) {
 	input := `"Hello" + " " + "World!"`
 
@@ -326,6 +745,17 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`len("hello world")`, 11},
 		{`len(1)`, "argument to `len` not supported, got INTEGER"},
 		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
+		{`int(3.9)`, 3},
+		{`int(-3.9)`, -3},
+		{`int(7)`, 7},
+		{`int("42")`, 42},
+		{`int("4.2")`, "could not parse \"4.2\" as integer"},
+		{`int(1.0 / 0.1)`, 10},
+		{`float(1) / 0`, "division by zero"},
+		{`int(true)`, "argument to `int` not supported, got BOOLEAN"},
+		{`float(3)`, 3.0},
+		{`float("0.25")`, 0.25},
+		{`float([])`, "argument to `float` not supported, got ARRAY"},
 	}
 
 	for _, tt := range tests {
@@ -334,6 +764,8 @@ func TestBuiltinFunctions(t *testing.T) {
 		switch expected := tt.expected.(type) {
 		case int:
 			testIntegerObject(t, evaluated, int64(expected))
+		case float64:
+			testFloatObject(t, evaluated, expected)
 		case string:
 			errObj, ok := evaluated.(*object.Error)
 			if !ok {
@@ -349,6 +781,99 @@ func TestBuiltinFunctions(t *testing.T) {
 	}
 }
 
+func TestHashBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`keys({"b": 1, "a": 2})`, "[b, a]"},
+		{`values({"b": 1, "a": 2})`, "[1, 2]"},
+		{`entries({"b": 1, [2]: 3})`, "[[b, 1], [[2], 3]]"},
+		{`has({"a": 1}, "a")`, "true"},
+		{`has({"a": 1}, "b")`, "false"},
+		{`if (has({}, 1)) { "yes" } else { "no" }`, "no"},
+		{`let h = {"a": 1, "b": 2}; delete(h, "a")`, "{b: 2}"},
+		{`let h = {"a": 1, "b": 2}; delete(h, "a"); h`, "{a: 1, b: 2}"},
+		{`delete({"a": 1}, "x")`, "{a: 1}"},
+		{`merge({"a": 1, "b": 2}, {"b": 3, "c": 4})`, "{a: 1, b: 3, c: 4}"},
+		{`len({"a": 1, "b": 2})`, "2"},
+		{`len({})`, "0"},
+		{`keys([1])`, "ERROR: argument to `keys` must be HASH, got ARRAY"},
+		{`values({}, 1)`, "ERROR: wrong number of arguments. got=2, want=1"},
+		{`has({})`, "ERROR: wrong number of arguments. got=1, want=2"},
+		{`has({}, fn() {})`, "ERROR: unusable as hash key: FUNCTION"},
+		{`delete({}, fn() {})`, "ERROR: unusable as hash key: FUNCTION"},
+		{`merge({}, [])`, "ERROR: argument to `merge` must be HASH, got ARRAY"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if errObj, ok := evaluated.(*object.Error); ok {
+			evaluated = &object.Error{Message: errObj.Message} // without position
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. want=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestCollectionBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"map([1, 2, 3], fn(x) { x * 2 })", "[2, 4, 6]"},
+		{`map(["1", "2"], int)`, "[1, 2]"},
+		{"map([], fn(x) { x })", "[]"},
+		{"filter([1, 2, 3, 4], fn(x) { x % 2 == 0 })", "[2, 4]"},
+		{"reduce([1, 2, 3], fn(a, b) { a + b })", "6"},
+		{`reduce(["a", "b"], fn(s, x) { s + x }, ">")`, ">ab"},
+		{"reduce([], fn(a, b) { a + b }, 0)", "0"},
+		{"find([1, 5, 7], fn(x) { x > 4 })", "5"},
+		{"find([1], fn(x) { x > 4 })", "null"},
+		{"any([1, 5], fn(x) { x > 4 })", "true"},
+		{"all([1, 5], fn(x) { x > 4 })", "false"},
+		{"all([], fn(x) { false })", "true"},
+		{`sort([3, 1.5, 2, -1])`, "[-1, 1.5, 2, 3]"},
+		{`sort(["b", "c", "a"])`, "[a, b, c]"},
+		{"sort([1, 3, 2], fn(a, b) { b - a })", "[3, 2, 1]"},
+		{"let a = [2, 1]; sort(a); a", "[2, 1]"},
+		{"reverse([1, 2, 3])", "[3, 2, 1]"},
+		{"slice([1, 2, 3, 4], 1, 3)", "[2, 3]"},
+		{"slice([1, 2, 3, 4], -2)", "[3, 4]"},
+		{"slice([1, 2, 3], 2, 1)", "[]"},
+		{"slice([1, 2, 3], 1, 100)", "[2, 3]"},
+		{"concat([1], [2, 3], [])", "[1, 2, 3]"},
+		{"range(3)", "[0, 1, 2]"},
+		{"range(1, 4)", "[1, 2, 3]"},
+		{"range(5, 0, -2)", "[5, 3, 1]"},
+		{`zip([1, 2, 3], ["a", "b"])`, "[[1, a], [2, b]]"},
+		{"map([[1, 2], [3]], fn(a) { reduce(a, fn(x, y) { x + y }) })", "[3, 3]"},
+		{"map(1, fn(x) { x })", "ERROR: argument to `map` must be ARRAY, got INTEGER"},
+		{"map([1], 1)", "ERROR: argument to `map` must be FUNCTION, got INTEGER"},
+		{"map([1], fn(a, b) { a })", "ERROR: wrong number of arguments: want=2, got=1"},
+		{"map([1], fn(x) { x + true })", "ERROR: type mismatch: INTEGER + BOOLEAN"},
+		{"reduce([], fn(a, b) { a + b })", "ERROR: reduce of empty array with no initial value"},
+		{"reduce([1])", "ERROR: wrong number of arguments. got=1, want=2 to 3"},
+		{`sort(["a", 1])`, "ERROR: type mismatch: INTEGER < STRING"},
+		{"sort([1, 2], fn(a, b) { true })", "ERROR: comparator must return INTEGER, got BOOLEAN"},
+		{`slice([1], "a")`, "ERROR: slice index must be INTEGER, got STRING"},
+		{"concat([1], 2)", "ERROR: argument to `concat` must be ARRAY, got INTEGER"},
+		{"range(0, 5, 0)", "ERROR: range step must not be zero"},
+		{"zip([1], 2)", "ERROR: argument to `zip` must be ARRAY, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if errObj, ok := evaluated.(*object.Error); ok {
+			evaluated = &object.Error{Message: errObj.Message} // without position
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. want=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
 func TestArrayLiterals(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
 	evaluated := testEval(input)
@@ -365,6 +890,115 @@ func TestArrayLiterals(t *testing.T) {
 	testIntegerObject(t, result.Elements[2], 6)
 }
 
+func TestStringIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"abc"[0]`, "a"},
+		{`let s = "abc"; s[len(s) - 1]`, "c"},
+		{`"abc"[3]`, "null"},
+		{`"abc"[-1]`, "null"},
+		{`"abcde"[1:3]`, "bc"},
+		{`"abcde"[:2]`, "ab"},
+		{`"abcde"[3:]`, "de"},
+		{`"abcde"[-2:]`, "de"},
+		{`"abcde"[:-1]`, "abcd"},
+		{`"abc"[2:1]`, ""},
+		{`"abc"[1:100]`, "bc"},
+		{"[1, 2, 3][1:]", "[2, 3]"},
+		{"let a = [1, 2]; let b = a[:]; b[0] = 5; a", "[1, 2]"},
+		{`"héllo"[1]`, "é"},
+		{`"héllo"[1:3]`, "él"},
+		{`"héllo"[-4:]`, "éllo"},
+		{`"日本"[1]`, "本"},
+		{`"日本"[2]`, "null"},
+		{`"abc"[1:"x"]`, "ERROR: slice index must be INTEGER, got STRING"},
+		{`{"a": 1}[0:1]`, "ERROR: slice operator not supported: HASH"},
+		{`"abc"["x"]`, "ERROR: index operator not supported: STRING"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if errObj, ok := evaluated.(*object.Error); ok {
+			evaluated = &object.Error{Message: errObj.Message} // without position
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. want=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestStringBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`split("a,b,,c", ",")`, "[a, b, , c]"},
+		{`split("abc", "")`, "[a, b, c]"},
+		{`join(["a", "b", "c"], ", ")`, "a, b, c"},
+		{`join([], "-")`, ""},
+		{`trim(" \t a b \n ")`, "a b"},
+		{`upper("Monkey")`, "MONKEY"},
+		{`lower("Monkey")`, "monkey"},
+		{`contains("monkey", "key")`, "true"},
+		{`contains("monkey", "dog")`, "false"},
+		{`startsWith("monkey", "mon")`, "true"},
+		{`endsWith("monkey", "mon")`, "false"},
+		{`replace("a-b-c", "-", "+")`, "a+b+c"},
+		{`indexOf("monkey", "key")`, "3"},
+		{`indexOf("monkey", "dog")`, "-1"},
+		{`substr("monkey", 3)`, "key"},
+		{`substr("monkey", 1, 3)`, "onk"},
+		{`substr("monkey", -3, 2)`, "ke"},
+		{`substr("monkey", 4, 10)`, "ey"},
+		{`repeat("ab", 3)`, "ababab"},
+		{`repeat("ab", 0)`, ""},
+		{`slice("monkey", 1, -1)`, "onke"},
+		{`format("%s has %d items costing %.2f", "cart", 3, 9.5)`, "cart has 3 items costing 9.50"},
+		{`format("%v %s %5s|%-3d|%x", 2.0, [1, "a"], "ab", 7, 255)`, "2.0 [1, a]    ab|7  |ff"},
+		{`format("%q %t", "hi", true)`, `"hi" true`},
+		{`format("%d%% %.1f %e %X %c", 50, 2, 10, "hi", 65)`, "50% 2.0 1.000000e+01 6869 A"},
+		{`format("%.0f", 10000000000000000000000)`, "10000000000000000000000"},
+		{`len("héllo")`, "5"},
+		{`len(bytes("héllo"))`, "6"},
+		{`bytes("é")`, "[195, 169]"},
+		{`indexOf("héllo", "l")`, "2"},
+		{`substr("日本語です", 1, 2)`, "本語"},
+		{`upper("café")`, "CAFÉ"},
+		{`let s = ""; for (c in "日本") { let s = c + s; } s`, "本日"},
+		{`let café = "ok"; café`, "ok"},
+		{`bytes(1)`, "ERROR: argument to `bytes` must be STRING, got INTEGER"},
+		{`split("a", 1)`, "ERROR: argument to `split` must be STRING, got INTEGER"},
+		{`upper()`, "ERROR: wrong number of arguments. got=0, want=1"},
+		{`join(["a", 1], "")`, "ERROR: elements joined by `join` must be STRING, got INTEGER"},
+		{`join("a", "")`, "ERROR: argument to `join` must be ARRAY, got STRING"},
+		{`substr("abc", 0, -1)`, "ERROR: length of `substr` must be a non-negative INTEGER, got -1"},
+		{`repeat("a", -1)`, "ERROR: negative repeat count: -1"},
+		{`repeat("ab", 1000000000000)`, "ERROR: repeat count too large: 1000000000000"},
+		{`format(1)`, "ERROR: argument to `format` must be STRING, got INTEGER"},
+		{`format()`, "ERROR: wrong number of arguments. got=0, want at least 1"},
+		{`format("%d %d", 1)`, "ERROR: wrong number of arguments. got=2, want=3"},
+		{`format("%d", 1, 2)`, "ERROR: wrong number of arguments. got=3, want=2"},
+		{`format("%d", "abc")`, "ERROR: argument to `format` must be INTEGER, got STRING"},
+		{`format("%.2f", "abc")`, "ERROR: argument to `format` must be FLOAT or INTEGER, got STRING"},
+		{`format("%t", 1)`, "ERROR: argument to `format` must be BOOLEAN, got INTEGER"},
+		{`format("%z", 1)`, "ERROR: unsupported verb in format: %z"},
+		{`format("%*d", 1, 2)`, "ERROR: unsupported verb in format: %*"},
+		{`format("100%")`, "ERROR: missing verb at end of format: %"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if errObj, ok := evaluated.(*object.Error); ok {
+			evaluated = &object.Error{Message: errObj.Message} // without position
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. want=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
 func TestArrayIndexExpressions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -439,25 +1073,37 @@ func TestHashLiterals(t *testing.T) {
 		t.Fatalf("Eval didn't return Hash. got=%T (%+v)", evaluated, evaluated)
 	}
 
-	expected := map[object.HashKey]int64{
-		(&object.String{Value: "one"}).HashKey():   1,
-		(&object.String{Value: "two"}).HashKey():   2,
-		(&object.String{Value: "three"}).HashKey(): 3,
-		(&object.Integer{Value: 4}).HashKey():      4,
-		TRUE.HashKey():                             5,
-		FALSE.HashKey():                            6,
+	expected := map[object.Object]int64{
+		&object.String{Value: "one"}:   1,
+		&object.String{Value: "two"}:   2,
+		&object.String{Value: "three"}: 3,
+		&object.Integer{Value: 4}:      4,
+		TRUE:                           5,
+		FALSE:                          6,
 	}
 
-	if len(result.Pairs) != len(expected) {
-		t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
+	if result.Len() != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got=%d", result.Len())
 	}
 
 	for expectedKey, expectedValue := range expected {
-		pair, ok := result.Pairs[expectedKey]
+		value, ok, _ := result.Get(expectedKey)
 		if !ok {
-			t.Errorf("no pair for given key in Pairs")
+			t.Errorf("no pair for key %s in Pairs", expectedKey.Inspect())
 		}
-		testIntegerObject(t, pair.Value, expectedValue)
+		testIntegerObject(t, value, expectedValue)
+	}
+}
+
+func TestHashOrder(t *testing.T) {
+	evaluated := testEval(`let h = {"b": 1, "a": 2}; h["c"] = 3; h["b"] = 4; h`)
+	if evaluated.Inspect() != "{b: 4, a: 2, c: 3}" {
+		t.Errorf("hash is not in insertion order. got=%s", evaluated.Inspect())
+	}
+
+	evaluated = testEval(`let s = ""; for (k in {"b": 1, "a": 2, "c": 3}) { let s = s + k; } s`)
+	if evaluated.Inspect() != "bac" {
+		t.Errorf("for-in doesn't iterate keys in insertion order. got=%s", evaluated.Inspect())
 	}
 }
 
@@ -494,6 +1140,22 @@ func TestHashIndexExpressions(t *testing.T) {
 			`{false: 5}[false]`,
 			5,
 		},
+		{
+			"let h = {[2024, 1]: 5}; h[[2024, 1]]",
+			5,
+		},
+		{
+			`{{"a": [1], "b": 2}: 3}[{"b": 2, "a": [1]}]`,
+			3,
+		},
+		{
+			"let k = [1]; let h = {}; h[k] = 1; k[0] = 2; h[[1]]",
+			1,
+		},
+		{
+			"{1: 2}[1.0]",
+			2,
+		},
 	}
 
 	for _, tt := range tests {
@@ -506,3 +1168,63 @@ func TestHashIndexExpressions(t *testing.T) {
 		}
 	}
 }
+
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedInspect string
+	}{
+		{"5 + true;", "ERROR: 1:1: type mismatch: INTEGER + BOOLEAN"},
+		{"let x = 1;\nlet y = x + foobar;", "ERROR: 2:13: identifier not found: foobar"},
+		{"let f = fn(x) {\n  -x\n};\nf(true)", "ERROR: 2:3: unknown operator: -BOOLEAN"},
+		{`len(1)`, "ERROR: 1:1: argument to `len` not supported, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+		assert.Equal(t, tt.expectedInspect, errObj.Inspect())
+	}
+}
+
+func TestErrorStackTrace(t *testing.T) {
+	input := `let add = fn(a, b) {
+  a + b
+};
+let compute = fn(x) {
+  add(x, "s")
+};
+let wrap = fn(f) { fn() { f(1) } };
+wrap(compute)();`
+
+	evaluated := testEval(input)
+	errObj, ok := evaluated.(*object.Error)
+	require.True(t, ok, "no error object returned. got=%T(%+v)", evaluated, evaluated)
+
+	assert.Equal(t, "ERROR: 2:3: type mismatch: INTEGER + STRING", errObj.Inspect())
+	expected := []string{
+		"add called at 5:3",
+		"compute called at 7:27",
+		"<anonymous> called at 8:1",
+	}
+	require.Len(t, errObj.Stack, len(expected))
+	for i, frame := range errObj.Stack {
+		assert.Equal(t, expected[i], frame.String())
+	}
+	assert.Equal(t, "\tin add called at 5:3\n\tin compute called at 7:27\n\tin <anonymous> called at 8:1\n", errObj.StackTrace())
+}
+
+func TestBuiltinErrorStackTrace(t *testing.T) {
+	evaluated := testEval(`let f = fn(x) { len(x) }; f(1)`)
+	errObj, ok := evaluated.(*object.Error)
+	require.True(t, ok, "no error object returned. got=%T(%+v)", evaluated, evaluated)
+
+	require.Len(t, errObj.Stack, 2)
+	assert.Equal(t, "len", errObj.Stack[0].Function)
+	assert.Equal(t, "f", errObj.Stack[1].Function)
+}
@@ -1,16 +1,38 @@
 package lexer
 
-import "github.com/idexter/monkey/token"
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/idexter/monkey/token"
+)
+
+// Lexer splits UTF-8 encoded source code into tokens.
 type Lexer struct {
+	filename     string
 	input        string
 	position     int  // current position in input (points to current char)
 	readPosition int  // current reading position in input (after current char)
-	ch           byte // current char under examination
+	ch           rune // current char under examination
+	line         int  // line of the current char
+	column       int  // column of the current char, counted in chars
+
+	// interpolations holds the number of unclosed braces in each expression
+	// embedded in an interpolated string which is being read.
+	interpolations []int
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewFile("", input)
+}
+
+// NewFile creates Lexer which reports token positions relative to the given file.
+func NewFile(filename, input string) *Lexer {
+	l := &Lexer{filename: filename, input: input, line: 1}
 	l.readChar()
 	return l
 }
@@ -19,38 +41,45 @@ func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
 
 	l.skipWhitespace()
+	pos := l.pos()
 
 	switch l.ch {
 	case '=':
-		if l.peekChar() == '=' {
-			ch := l.ch
-			l.readChar()
-			literal := string(ch) + string(l.ch)
-			tok = token.Token{Type: token.EQ, Literal: literal}
-		} else {
-			tok = token.New(token.ASSIGN, l.ch)
-		}
+		tok = l.readOperator('=', token.EQ, token.ASSIGN)
 	case '+':
-		tok = token.New(token.PLUS, l.ch)
+		tok = l.readOperator('=', token.PLUS_ASSIGN, token.PLUS)
 	case '-':
-		tok = token.New(token.MINUS, l.ch)
+		tok = l.readOperator('=', token.MINUS_ASSIGN, token.MINUS)
 	case '!':
-		if l.peekChar() == '=' {
-			ch := l.ch
-			l.readChar()
-			literal := string(ch) + string(l.ch)
-			tok = token.Token{Type: token.NOT_EQ, Literal: literal}
-		} else {
-			tok = token.New(token.BANG, l.ch)
-		}
+		tok = l.readOperator('=', token.NOT_EQ, token.BANG)
 	case '/':
-		tok = token.New(token.SLASH, l.ch)
+		tok = l.readOperator('=', token.SLASH_ASSIGN, token.SLASH)
 	case '*':
-		tok = token.New(token.ASTERISK, l.ch)
+		if l.peekChar() == '*' {
+			tok = l.readOperator('*', token.POWER, token.ASTERISK)
+		} else {
+			tok = l.readOperator('=', token.ASTERISK_ASSIGN, token.ASTERISK)
+		}
+	case '%':
+		tok = token.New(token.PERCENT, l.ch)
+	case '&':
+		tok = l.readOperator('&', token.AND, token.BIT_AND)
+	case '|':
+		tok = l.readOperator('|', token.OR, token.BIT_OR)
+	case '^':
+		tok = token.New(token.BIT_XOR, l.ch)
 	case '<':
-		tok = token.New(token.LT, l.ch)
+		if l.peekChar() == '<' {
+			tok = l.readOperator('<', token.SHIFT_LEFT, token.LT)
+		} else {
+			tok = l.readOperator('=', token.LT_EQ, token.LT)
+		}
 	case '>':
-		tok = token.New(token.GT, l.ch)
+		if l.peekChar() == '>' {
+			tok = l.readOperator('>', token.SHIFT_RIGHT, token.GT)
+		} else {
+			tok = l.readOperator('=', token.GT_EQ, token.GT)
+		}
 	case ';':
 		tok = token.New(token.SEMICOLON, l.ch)
 	case ',':
@@ -60,8 +89,23 @@ func (l *Lexer) NextToken() token.Token {
 	case ')':
 		tok = token.New(token.RPAREN, l.ch)
 	case '{':
+		if n := len(l.interpolations); n > 0 {
+			l.interpolations[n-1]++
+		}
 		tok = token.New(token.LBRACE, l.ch)
 	case '}':
+		n := len(l.interpolations)
+		if n > 0 && l.interpolations[n-1] == 0 {
+			// The brace ends an expression embedded in a string, so the
+			// rest of the string follows.
+			l.interpolations = l.interpolations[:n-1]
+			tok = l.readString()
+			tok.Pos, tok.End = pos, l.pos()
+			return tok
+		}
+		if n > 0 {
+			l.interpolations[n-1]--
+		}
 		tok = token.New(token.RBRACE, l.ch)
 	case '[':
 		tok = token.New(token.LBRACKET, l.ch)
@@ -69,15 +113,18 @@ func (l *Lexer) NextToken() token.Token {
 		tok = token.New(token.RBRACKET, l.ch)
 	case ':':
 		tok = token.New(token.COLON, l.ch)
-	case '"':
-		tok.Type = token.STRING
-		tok.Literal = l.readString()
+	case '"', '`':
+		tok = l.readString()
+		tok.Pos, tok.End = pos, l.pos()
+		return tok
 	case '#':
 		tok.Type = token.SHARP
 		tok.Literal = l.readComment()
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
+		tok.Pos, tok.End = pos, pos
+		return tok
 	default:
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
@@ -88,10 +135,11 @@ func (l *Lexer) NextToken() token.Token {
 			// character of the current identifier.
 			// So we don’t need the call to readChar() after the switch
 			// statement again.
+			tok.Pos, tok.End = pos, l.pos()
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Type = token.INT
-			tok.Literal = l.readNumber()
+			tok.Type, tok.Literal = l.readNumber()
+			tok.Pos, tok.End = pos, l.pos()
 			return tok
 		} else {
 			tok = token.New(token.ILLEGAL, l.ch)
@@ -99,17 +147,48 @@ func (l *Lexer) NextToken() token.Token {
 	}
 
 	l.readChar()
+	tok.Pos, tok.End = pos, l.pos()
 	return tok
 }
 
+// readOperator returns the two-character operator twoChar if the current char
+// is followed by next, otherwise the single-character operator oneChar.
+func (l *Lexer) readOperator(next rune, twoChar, oneChar token.Type) token.Token {
+	if l.peekChar() == next {
+		ch := l.ch
+		l.readChar()
+		return token.Token{Type: twoChar, Literal: string(ch) + string(l.ch)}
+	}
+	return token.New(oneChar, l.ch)
+}
+
+// pos returns position of the current char.
+func (l *Lexer) pos() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.position,
+		Line:     l.line,
+		Column:   l.column,
+	}
+}
+
+// readChar decodes the next char of the input. Bytes which aren't valid UTF-8
+// are read one at a time as utf8.RuneError.
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	l.column++
+
+	width := 1
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
-		l.ch = l.input[l.readPosition]
+		l.ch, width = utf8.DecodeRuneInString(l.input[l.readPosition:])
 	}
 	l.position = l.readPosition
-	l.readPosition += 1
+	l.readPosition += width
 }
 
 func (l *Lexer) readIdentifier() string {
@@ -120,12 +199,53 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.position]
 }
 
-func (l *Lexer) readNumber() string {
+// readNumber reads an integer, or a floating-point number if the digits
+// are followed by a fraction (1.5), an exponent (2e10) or both (2.5e-3).
+// Integers may be hexadecimal (0xFF), octal (0o17) or binary (0b1010), and
+// digits may be separated by underscores (1_000). Letters and digits after
+// the prefix of a hexadecimal, octal or binary integer are all read as part
+// of it, so that the parser can report an invalid digit in it.
+func (l *Lexer) readNumber() (token.Type, string) {
 	position := l.position
-	for isDigit(l.ch) {
+	var tokenType token.Type = token.INT
+
+	if l.ch == '0' && strings.ContainsRune("xXoObB", l.peekChar()) {
+		l.readChar()
+		l.readChar()
+		for isLetter(l.ch) || isDigit(l.ch) {
+			l.readChar()
+		}
+		return tokenType, l.input[position:l.position]
+	}
+
+	l.readDigits()
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tokenType = token.FLOAT
+		l.readChar()
+		l.readDigits()
+	}
+	if l.ch == 'e' || l.ch == 'E' {
+		n := 1
+		if sign := l.peekCharAt(n); sign == '+' || sign == '-' {
+			n++
+		}
+		if isDigit(l.peekCharAt(n)) {
+			tokenType = token.FLOAT
+			for ; n > 0; n-- {
+				l.readChar()
+			}
+			l.readDigits()
+		}
+	}
+
+	return tokenType, l.input[position:l.position]
+}
+
+// readDigits reads decimal digits and the underscores separating them.
+func (l *Lexer) readDigits() {
+	for isDigit(l.ch) || l.ch == '_' {
 		l.readChar()
 	}
-	return l.input[position:l.position]
 }
 
 func (l *Lexer) skipWhitespace() {
@@ -134,25 +254,148 @@ func (l *Lexer) skipWhitespace() {
 	}
 }
 
-func (l *Lexer) peekChar() byte {
+func (l *Lexer) peekChar() rune {
 	if l.readPosition >= len(l.input) {
 		return 0
 	} else {
-		return l.input[l.readPosition]
+		ch, _ := utf8.DecodeRuneInString(l.input[l.readPosition:])
+		return ch
 	}
 }
 
-func (l *Lexer) readString() string {
-	position := l.position + 1
+// peekCharAt returns the byte n bytes after the current char. It is only used
+// to look for ASCII chars, which are a single byte.
+func (l *Lexer) peekCharAt(n int) rune {
+	if l.position+n >= len(l.input) {
+		return 0
+	}
+	return rune(l.input[l.position+n])
+}
+
+// readString reads a string literal enclosed in the current char, which is
+// either a double quote or a backtick. Both may span lines. Escape sequences
+// are decoded in double quoted strings, strings in backticks are raw: they
+// are taken as is. A string which isn't terminated or has an invalid escape
+// sequence is read as ILLEGAL token holding its source text.
+//
+// Expressions embedded in double quoted strings with ${...} split the string
+// into a STRING_HEAD token up to the "${", the tokens of the expression and
+// a STRING_MIDDLE or STRING_TAIL token from the closing '}', which readString
+// reads too.
+func (l *Lexer) readString() token.Token {
+	quote := l.ch
+	if quote == '}' {
+		quote = '"'
+	}
+	continued := l.ch == '}'
+	position := l.position
 	for {
 		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
+		if l.ch == quote || l.ch == 0 {
 			break
 		}
+		if l.ch == '$' && quote == '"' && l.peekChar() == '{' {
+			break
+		}
+		if l.ch == '\\' && quote == '"' && l.peekChar() != 0 {
+			l.readChar()
+		}
 	}
-	return l.input[position:l.position]
+
+	var tokenType token.Type
+	switch {
+	case l.ch == '$':
+		l.readChar()
+		l.interpolations = append(l.interpolations, 0)
+		tokenType = token.STRING_HEAD
+		if continued {
+			tokenType = token.STRING_MIDDLE
+		}
+	case l.ch == quote:
+		tokenType = token.STRING
+		if continued {
+			tokenType = token.STRING_TAIL
+		}
+	default:
+		return token.Token{Type: token.ILLEGAL, Literal: l.input[position:l.position]}
+	}
+	l.readChar()
+
+	literal := l.input[position:l.position]
+	value, err := Unquote(literal)
+	if err != nil {
+		return token.Token{Type: token.ILLEGAL, Literal: literal}
+	}
+	return token.Token{Type: tokenType, Literal: value}
+}
+
+// Unquote returns the value of the string literal, given together with its
+// quotes as it is written in the source code. It also accepts the parts of
+// interpolated strings, which start with '}' instead of the opening quote or
+// end with "${" instead of the closing one. The supported escape sequences
+// are \n, \t, \", \\, \$ and \u{...} with the hexadecimal code of a character.
+func Unquote(literal string) (string, error) {
+	if strings.HasPrefix(literal, "`") {
+		if len(literal) < 2 || !strings.HasSuffix(literal, "`") {
+			return "", ErrUnterminated
+		}
+		return literal[1 : len(literal)-1], nil
+	}
+
+	if literal == "" {
+		return "", ErrUnterminated
+	}
+	s := literal[1:]
+	switch {
+	case strings.HasSuffix(s, "${"):
+		s = s[:len(s)-2]
+	case strings.HasSuffix(s, `"`):
+		s = s[:len(s)-1]
+	default:
+		return "", ErrUnterminated
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			out.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i == len(s) {
+			// The backslash escapes the closing quote.
+			return "", ErrUnterminated
+		}
+		switch s[i] {
+		case 'n':
+			out.WriteByte('\n')
+		case 't':
+			out.WriteByte('\t')
+		case '"', '\\', '$':
+			out.WriteByte(s[i])
+		case 'u':
+			end := strings.IndexByte(s[i:], '}')
+			if !strings.HasPrefix(s[i:], "u{") || end < 0 {
+				return "", errors.New(`invalid Unicode escape sequence, want \u{...}`)
+			}
+			digits := s[i+2 : i+end]
+			code, err := strconv.ParseUint(digits, 16, 32)
+			if err != nil || len(digits) > 6 || !utf8.ValidRune(rune(code)) {
+				return "", fmt.Errorf("invalid Unicode code point \\u{%s}", digits)
+			}
+			out.WriteRune(rune(code))
+			i += end
+		default:
+			ch, _ := utf8.DecodeRuneInString(s[i:])
+			return "", fmt.Errorf("invalid escape sequence \\%c", ch)
+		}
+	}
+	return out.String(), nil
 }
 
+// ErrUnterminated is returned by Unquote for strings without the closing quote.
+var ErrUnterminated = errors.New("unterminated string literal")
+
 func (l *Lexer) readComment() string {
 	position := l.position + 1
 	for {
@@ -164,10 +407,12 @@ func (l *Lexer) readComment() string {
 	return l.input[position:l.position]
 }
 
-func isDigit(ch byte) bool {
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
-func isLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+// isLetter reports whether ch can be part of an identifier: Unicode letters
+// and the underscore.
+func isLetter(ch rune) bool {
+	return unicode.IsLetter(ch) || ch == '_'
 }
@@ -136,3 +136,231 @@ if (5 < 10) {
 		assert.Equal(t, tt.expectedLiteral, tok.Literal, "wrong literal.")
 	}
 }
+
+func TestNumbers(t *testing.T) {
+	input := `5 3.14 0.5 1e10 2.5E-3 6e+2 1. 2e`
+
+	tests := []struct {
+		expectedType    token.Type
+		expectedLiteral string
+	}{
+		{token.INT, "5"},
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, "0.5"},
+		{token.FLOAT, "1e10"},
+		{token.FLOAT, "2.5E-3"},
+		{token.FLOAT, "6e+2"},
+		{token.INT, "1"},
+		{token.ILLEGAL, "."},
+		{token.INT, "2"},
+		{token.IDENT, "e"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for _, tt := range tests {
+		tok := l.NextToken()
+		assert.Equal(t, tt.expectedType, tok.Type, "wrong token type.")
+		assert.Equal(t, tt.expectedLiteral, tok.Literal, "wrong literal.")
+	}
+}
+
+func TestIntegerBases(t *testing.T) {
+	input := `0xFF 0o17 0B1010 1_000 1_000.5 0xZZ 0b2; 0x.5 0 x`
+
+	tests := []struct {
+		expectedType    token.Type
+		expectedLiteral string
+	}{
+		{token.INT, "0xFF"},
+		{token.INT, "0o17"},
+		{token.INT, "0B1010"},
+		{token.INT, "1_000"},
+		{token.FLOAT, "1_000.5"},
+		{token.INT, "0xZZ"},
+		{token.INT, "0b2"},
+		{token.SEMICOLON, ";"},
+		{token.INT, "0x"},
+		{token.ILLEGAL, "."},
+		{token.INT, "5"},
+		{token.INT, "0"},
+		{token.IDENT, "x"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for _, tt := range tests {
+		tok := l.NextToken()
+		assert.Equal(t, tt.expectedType, tok.Type, "wrong token type.")
+		assert.Equal(t, tt.expectedLiteral, tok.Literal, "wrong literal.")
+	}
+}
+
+func TestOperators(t *testing.T) {
+	input := `x += 1; x -= 1; x *= 2; x /= 2; x = -1; a && b || c;
+a <= b >= c % d ** e & f | g ^ h << i >> j`
+
+	expected := []token.Type{
+		token.IDENT, token.PLUS_ASSIGN, token.INT, token.SEMICOLON,
+		token.IDENT, token.MINUS_ASSIGN, token.INT, token.SEMICOLON,
+		token.IDENT, token.ASTERISK_ASSIGN, token.INT, token.SEMICOLON,
+		token.IDENT, token.SLASH_ASSIGN, token.INT, token.SEMICOLON,
+		token.IDENT, token.ASSIGN, token.MINUS, token.INT, token.SEMICOLON,
+		token.IDENT, token.AND, token.IDENT, token.OR, token.IDENT, token.SEMICOLON,
+		token.IDENT, token.LT_EQ, token.IDENT, token.GT_EQ, token.IDENT, token.PERCENT,
+		token.IDENT, token.POWER, token.IDENT, token.BIT_AND, token.IDENT, token.BIT_OR,
+		token.IDENT, token.BIT_XOR, token.IDENT, token.SHIFT_LEFT, token.IDENT,
+		token.SHIFT_RIGHT, token.IDENT,
+		token.EOF,
+	}
+
+	l := New(input)
+
+	for _, tokenType := range expected {
+		tok := l.NextToken()
+		assert.Equal(t, tokenType, tok.Type, "wrong token type for %q.", tok.Literal)
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 5;\n  \"ab\" # c\nfoo"
+
+	tests := []struct {
+		expectedType token.Type
+		pos          token.Position
+		end          token.Position
+	}{
+		{token.LET, pos(0, 1, 1), pos(3, 1, 4)},
+		{token.IDENT, pos(4, 1, 5), pos(5, 1, 6)},
+		{token.ASSIGN, pos(6, 1, 7), pos(7, 1, 8)},
+		{token.INT, pos(8, 1, 9), pos(9, 1, 10)},
+		{token.SEMICOLON, pos(9, 1, 10), pos(10, 1, 11)},
+		{token.STRING, pos(13, 2, 3), pos(17, 2, 7)},
+		{token.SHARP, pos(18, 2, 8), pos(22, 3, 1)},
+		{token.IDENT, pos(22, 3, 1), pos(25, 3, 4)},
+		{token.EOF, pos(25, 3, 4), pos(25, 3, 4)},
+	}
+
+	l := NewFile("test.monkey", input)
+
+	for _, tt := range tests {
+		tok := l.NextToken()
+		tt.pos.Filename = "test.monkey"
+		tt.end.Filename = "test.monkey"
+		assert.Equal(t, tt.expectedType, tok.Type, "wrong token type.")
+		assert.Equal(t, tt.pos, tok.Pos, "wrong position of %q.", tok.Literal)
+		assert.Equal(t, tt.end, tok.End, "wrong end position of %q.", tok.Literal)
+	}
+}
+
+func pos(offset, line, column int) token.Position {
+	return token.Position{Offset: offset, Line: line, Column: column}
+}
+
+func TestUnicode(t *testing.T) {
+	input := "let café = \"héllo\";\nπ + \xff"
+
+	tests := []struct {
+		expectedType    token.Type
+		expectedLiteral string
+		pos             token.Position
+		end             token.Position
+	}{
+		{token.LET, "let", pos(0, 1, 1), pos(3, 1, 4)},
+		{token.IDENT, "café", pos(4, 1, 5), pos(9, 1, 9)},
+		{token.ASSIGN, "=", pos(10, 1, 10), pos(11, 1, 11)},
+		{token.STRING, "héllo", pos(12, 1, 12), pos(20, 1, 19)},
+		{token.SEMICOLON, ";", pos(20, 1, 19), pos(21, 1, 20)},
+		{token.IDENT, "π", pos(22, 2, 1), pos(24, 2, 2)},
+		{token.PLUS, "+", pos(25, 2, 3), pos(26, 2, 4)},
+		{token.ILLEGAL, "\uFFFD", pos(27, 2, 5), pos(28, 2, 6)},
+		{token.EOF, "", pos(28, 2, 6), pos(28, 2, 6)},
+	}
+
+	l := New(input)
+
+	for _, tt := range tests {
+		tok := l.NextToken()
+		assert.Equal(t, tt.expectedType, tok.Type, "wrong token type.")
+		assert.Equal(t, tt.expectedLiteral, tok.Literal, "wrong literal.")
+		assert.Equal(t, tt.pos, tok.Pos, "wrong position of %q.", tok.Literal)
+		assert.Equal(t, tt.end, tok.End, "wrong end position of %q.", tok.Literal)
+	}
+}
+
+func TestStrings(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.Type
+		expectedLiteral string
+	}{
+		{`"a\nb\tc"`, token.STRING, "a\nb\tc"},
+		{`"say \"hi\""`, token.STRING, `say "hi"`},
+		{`"back\\slash"`, token.STRING, `back\slash`},
+		{`"\u{48}\u{e9}\u{1F600}"`, token.STRING, "Hé\U0001F600"},
+		{"`raw\n\\n \"string\"`", token.STRING, "raw\n\\n \"string\""},
+		{`""`, token.STRING, ""},
+		{`"abc`, token.ILLEGAL, `"abc`},
+		{"\"abc\n\"", token.STRING, "abc\n"},
+		{`"abc\"`, token.ILLEGAL, `"abc\"`},
+		{"`abc", token.ILLEGAL, "`abc"},
+		{`"a\qb"`, token.ILLEGAL, `"a\qb"`},
+		{`"\u{}"`, token.ILLEGAL, `"\u{}"`},
+		{`"\u{D800}"`, token.ILLEGAL, `"\u{D800}"`},
+	}
+
+	for _, tt := range tests {
+		tok := New(tt.input).NextToken()
+		assert.Equal(t, tt.expectedType, tok.Type, "wrong token type of %q.", tt.input)
+		assert.Equal(t, tt.expectedLiteral, tok.Literal, "wrong literal of %q.", tt.input)
+	}
+}
+
+func TestMultilineString(t *testing.T) {
+	input := "`a\nb` x"
+
+	l := New(input)
+	tok := l.NextToken()
+	assert.Equal(t, token.Type(token.STRING), tok.Type)
+	assert.Equal(t, "a\nb", tok.Literal)
+	assert.Equal(t, pos(0, 1, 1), tok.Pos)
+	assert.Equal(t, pos(5, 2, 3), tok.End)
+
+	tok = l.NextToken()
+	assert.Equal(t, pos(6, 2, 4), tok.Pos)
+}
+
+func TestInterpolatedStrings(t *testing.T) {
+	input := `"a ${b + "c${d}"} {${ {}[e] }}" "\${f}"`
+
+	tests := []struct {
+		expectedType    token.Type
+		expectedLiteral string
+	}{
+		{token.STRING_HEAD, "a "},
+		{token.IDENT, "b"},
+		{token.PLUS, "+"},
+		{token.STRING_HEAD, "c"},
+		{token.IDENT, "d"},
+		{token.STRING_TAIL, ""},
+		{token.STRING_MIDDLE, " {"},
+		{token.LBRACE, "{"},
+		{token.RBRACE, "}"},
+		{token.LBRACKET, "["},
+		{token.IDENT, "e"},
+		{token.RBRACKET, "]"},
+		{token.STRING_TAIL, "}"},
+		{token.STRING, "${f}"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		assert.Equal(t, tt.expectedType, tok.Type, "tests[%d] - wrong token type.", i)
+		assert.Equal(t, tt.expectedLiteral, tok.Literal, "tests[%d] - wrong literal.", i)
+	}
+}
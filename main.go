@@ -6,14 +6,41 @@ import (
 	"os"
 	"os/user"
 
+	"github.com/idexter/monkey/evaluator"
+	"github.com/idexter/monkey/parser"
 	"github.com/idexter/monkey/repl"
 )
 
+// maxDepthLimit bounds -max-depth. The evaluator recurses on the Go stack, and
+// deeper Monkey calls could exhaust it, which crashes the program instead of
+// reporting the maximum recursion depth error.
+const maxDepthLimit = 30000
+
 func main() {
 	entrypoint := flag.String("in", "", "Runs script from file.\nUsage: monkeyc -in ./example.monkey")
 	runREPL := flag.Bool("repl", false, "Runs REPL")
+	engine := flag.String("engine", string(repl.EngineEval), "Engine to run code with: eval or vm")
+	maxDepth := flag.Int("max-depth", evaluator.DefaultMaxCallDepth, "Maximum number of nested function calls")
+	checked := flag.Bool("checked", false, "Report integer overflow as an error instead of promoting to big integers")
+	noRedeclare := flag.Bool("no-redeclare", false, "Report declaring a variable twice in the same scope as an error")
 	flag.Parse()
 
+	if *engine != string(repl.EngineEval) && *engine != string(repl.EngineVM) {
+		fmt.Printf("Unknown engine: %s\n", *engine)
+		os.Exit(2)
+	}
+
+	if *maxDepth < 1 || *maxDepth > maxDepthLimit {
+		fmt.Printf("Invalid max depth: %d, must be between 1 and %d\n", *maxDepth, maxDepthLimit)
+		os.Exit(2)
+	}
+	config := repl.Config{
+		Engine:            repl.Engine(*engine),
+		Parser:            parser.Config{ForbidRedeclaration: *noRedeclare},
+		CheckedArithmetic: *checked,
+		MaxCallDepth:      *maxDepth,
+	}
+
 	if *runREPL {
 		usr, err := user.Current()
 		if err != nil {
@@ -21,7 +48,7 @@ func main() {
 		}
 		fmt.Printf("Hello %s! This is the Monkey programming language!\n", usr.Username)
 		fmt.Printf("Feel free to type in commands\n")
-		repl.StartREPL(os.Stdin, os.Stdout)
+		repl.StartREPL(os.Stdin, os.Stdout, config)
 		return
 	}
 
@@ -33,7 +60,7 @@ func main() {
 			return
 		}
 
-		repl.RunScript(f, os.Stdout)
+		repl.RunScript(*entrypoint, f, os.Stdout, config)
 		return
 	}
 }
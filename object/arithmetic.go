@@ -0,0 +1,262 @@
+package object
+
+import (
+	"math"
+	"math/big"
+)
+
+// NewInteger returns v as *Integer if it fits in int64, or as *BigInteger.
+func NewInteger(v *big.Int) Object {
+	if v.IsInt64() {
+		return &Integer{Value: v.Int64()}
+	}
+	return &BigInteger{Value: v}
+}
+
+// bigValue returns the value of an *Integer or a *BigInteger as big.Int.
+func bigValue(obj Object) *big.Int {
+	if i, ok := obj.(*Integer); ok {
+		return big.NewInt(i.Value)
+	}
+	return obj.(*BigInteger).Value
+}
+
+// IntegerArithmetic computes left operator right for one of the integer
+// operators + - * / % ** & | ^ << and >>. Division by zero, negative exponents
+// and negative shift counts are errors. Results which overflow int64 are
+// promoted to BigInteger, unless checked is set, in which case the overflow
+// is an error.
+func IntegerArithmetic(operator string, left, right Object, checked bool) (Object, *Error) {
+	if err := checkOperand(operator, left, right); err != nil {
+		return nil, err
+	}
+
+	l, lok := left.(*Integer)
+	r, rok := right.(*Integer)
+	if lok && rok {
+		if result, ok := smallArithmetic(operator, l.Value, r.Value); ok {
+			return &Integer{Value: result}, nil
+		}
+		if checked {
+			return nil, newError("integer overflow: %d %s %d", l.Value, operator, r.Value)
+		}
+	}
+
+	x, y := bigValue(left), bigValue(right)
+	result := new(big.Int)
+	switch operator {
+	case "+":
+		result.Add(x, y)
+	case "-":
+		result.Sub(x, y)
+	case "*":
+		result.Mul(x, y)
+	case "/":
+		result.Quo(x, y)
+	case "%":
+		result.Rem(x, y)
+	case "**":
+		result.Exp(x, y, nil)
+	case "&":
+		result.And(x, y)
+	case "|":
+		result.Or(x, y)
+	case "^":
+		result.Xor(x, y)
+	case "<<":
+		result.Lsh(x, uint(y.Int64()))
+	case ">>":
+		result.Rsh(x, uint(y.Int64()))
+	default:
+		return nil, newError("unknown operator: %s %s %s", INTEGER_OBJ, operator, INTEGER_OBJ)
+	}
+	return NewInteger(result), nil
+}
+
+// maxIntegerBits limits the size of integers computed by ** and <<, which
+// could otherwise exhaust memory.
+const maxIntegerBits = 1 << 24
+
+// checkOperand reports right operands the operator isn't defined for, or which
+// would make the result too large to compute.
+func checkOperand(operator string, left, right Object) *Error {
+	switch operator {
+	case "/", "%":
+		// BigInteger is never zero, it would be demoted to Integer.
+		if i, ok := right.(*Integer); ok && i.Value == 0 {
+			return newError("division by zero")
+		}
+	case "**", "<<", ">>":
+		name := "shift count"
+		if operator == "**" {
+			name = "exponent"
+		}
+
+		n := bigValue(right)
+		if n.Sign() < 0 {
+			return newError("negative %s: %s", name, right.Inspect())
+		}
+		bits := int64(1)
+		if operator == "**" {
+			bits = int64(bigValue(left).BitLen() - 1)
+		}
+		if !n.IsInt64() || (operator != ">>" && bits > 0 && n.Int64() > maxIntegerBits/bits) {
+			return newError("%s too large: %s", name, right.Inspect())
+		}
+	}
+	return nil
+}
+
+// smallArithmetic computes left operator right unless it overflows int64 or
+// the operands are invalid.
+func smallArithmetic(operator string, left, right int64) (int64, bool) {
+	switch operator {
+	case "+":
+		result := left + right
+		return result, (right >= 0) == (result >= left)
+	case "-":
+		result := left - right
+		return result, (right >= 0) == (result <= left)
+	case "*":
+		if left == 0 || right == 0 {
+			return 0, true
+		}
+		result := left * right
+		// result/right can't detect math.MinInt64 * -1, which wraps to itself.
+		return result, result/right == left && !(right == -1 && left == math.MinInt64)
+	case "/":
+		if right == 0 || (left == math.MinInt64 && right == -1) {
+			return 0, false
+		}
+		return left / right, true
+	case "%":
+		if right == 0 {
+			return 0, false
+		}
+		return left % right, true
+	case "**":
+		return smallPower(left, right)
+	case "&":
+		return left & right, true
+	case "|":
+		return left | right, true
+	case "^":
+		return left ^ right, true
+	case "<<":
+		if right < 0 || right >= 64 {
+			return 0, left == 0 && right >= 0
+		}
+		result := left << uint(right)
+		return result, result>>uint(right) == left
+	case ">>":
+		if right < 0 {
+			return 0, false
+		}
+		if right >= 64 {
+			right = 63
+		}
+		return left >> uint(right), true
+	default:
+		return 0, false
+	}
+}
+
+// smallPower computes base**exp by repeated squaring unless it overflows.
+func smallPower(base, exp int64) (int64, bool) {
+	if exp < 0 {
+		return 0, false
+	}
+	result := int64(1)
+	var ok bool
+	for exp > 0 {
+		if exp&1 == 1 {
+			if result, ok = smallArithmetic("*", result, base); !ok {
+				return 0, false
+			}
+		}
+		exp >>= 1
+		if exp > 0 {
+			// The square is part of the result, so its overflow is too.
+			if base, ok = smallArithmetic("*", base, base); !ok {
+				return 0, false
+			}
+		}
+	}
+	return result, true
+}
+
+// NegateInteger returns -obj for an *Integer or a *BigInteger. Negating
+// math.MinInt64 overflows, which is an error if checked is set.
+func NegateInteger(obj Object, checked bool) (Object, *Error) {
+	if i, ok := obj.(*Integer); ok {
+		if i.Value != math.MinInt64 {
+			return &Integer{Value: -i.Value}, nil
+		}
+		if checked {
+			// The operand is negative, so it's parenthesized.
+			return nil, newError("integer overflow: -(%d)", i.Value)
+		}
+	}
+	return NewInteger(new(big.Int).Neg(bigValue(obj))), nil
+}
+
+// CompareIntegers returns -1, 0 or +1 depending on whether left is less than,
+// equal to or greater than right, which are *Integer or *BigInteger.
+func CompareIntegers(left, right Object) int {
+	l, lok := left.(*Integer)
+	r, rok := right.(*Integer)
+	if lok && rok {
+		switch {
+		case l.Value < r.Value:
+			return -1
+		case l.Value > r.Value:
+			return 1
+		default:
+			return 0
+		}
+	}
+	return bigValue(left).Cmp(bigValue(right))
+}
+
+// FloatArithmetic computes left operator right for one of the arithmetic
+// operators + - * / % and **. Unlike IEEE 754, division by zero is an error,
+// the same as for integers.
+func FloatArithmetic(operator string, left, right float64) (float64, *Error) {
+	switch operator {
+	case "+":
+		return left + right, nil
+	case "-":
+		return left - right, nil
+	case "*":
+		return left * right, nil
+	case "/":
+		if right == 0 {
+			return 0, newError("division by zero")
+		}
+		return left / right, nil
+	case "%":
+		if right == 0 {
+			return 0, newError("division by zero")
+		}
+		return math.Mod(left, right), nil
+	case "**":
+		return math.Pow(left, right), nil
+	default:
+		return 0, newError("unknown operator: %s %s %s", FLOAT_OBJ, operator, FLOAT_OBJ)
+	}
+}
+
+// ToFloat returns the value of an integer or a float as float64.
+func ToFloat(obj Object) (float64, bool) {
+	switch obj := obj.(type) {
+	case *Integer:
+		return float64(obj.Value), true
+	case *BigInteger:
+		f, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return f, true
+	case *Float:
+		return obj.Value, true
+	default:
+		return 0, false
+	}
+}
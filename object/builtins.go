@@ -0,0 +1,1033 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Builtins contains builtin functions available to Monkey programs.
+// The compiler refers to builtins by their index, so new ones have to be
+// appended to the end.
+var Builtins = []struct {
+	Name    string
+	Builtin *Builtin
+}{
+	{
+		"len",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			switch arg := args[0].(type) {
+			case *Array:
+				return &Integer{Value: int64(len(arg.Elements))}
+			case *String:
+				return &Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
+			case *Hash:
+				return &Integer{Value: int64(arg.Len())}
+			default:
+				return newError("argument to `len` not supported, got %s", args[0].Type())
+			}
+		}},
+	},
+	{
+		"puts",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			for _, arg := range args {
+				fmt.Println(arg.Inspect())
+			}
+			return nil
+		}},
+	},
+	{
+		"first",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			if args[0].Type() != ARRAY_OBJ {
+				return newError("argument to `first` must be ARRAY, got %s", args[0].Type())
+			}
+			arr := args[0].(*Array)
+			if len(arr.Elements) > 0 {
+				return arr.Elements[0]
+			}
+			return nil
+		}},
+	},
+	{
+		"last",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			if args[0].Type() != ARRAY_OBJ {
+				return newError("argument to `last` must be ARRAY, got %s", args[0].Type())
+			}
+			arr := args[0].(*Array)
+			length := len(arr.Elements)
+			if length > 0 {
+				return arr.Elements[length-1]
+			}
+			return nil
+		}},
+	},
+	{
+		"rest",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			if args[0].Type() != ARRAY_OBJ {
+				return newError("argument to `rest` must be ARRAY, got %s", args[0].Type())
+			}
+			arr := args[0].(*Array)
+			length := len(arr.Elements)
+			if length > 0 {
+				newElements := make([]Object, length-1, length-1)
+				copy(newElements, arr.Elements[1:length])
+				return &Array{Elements: newElements}
+			}
+			return nil
+		}},
+	},
+	{
+		"push",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2",
+					len(args))
+			}
+			if args[0].Type() != ARRAY_OBJ {
+				return newError("argument to `push` must be ARRAY, got %s", args[0].Type())
+			}
+			arr := args[0].(*Array)
+			length := len(arr.Elements)
+			newElements := make([]Object, length+1, length+1)
+			copy(newElements, arr.Elements)
+			newElements[length] = args[1]
+			return &Array{Elements: newElements}
+		}},
+	},
+	{
+		"int",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			switch arg := args[0].(type) {
+			case *Integer, *BigInteger:
+				return arg
+			case *Float:
+				if math.IsNaN(arg.Value) || math.IsInf(arg.Value, 0) {
+					return newError("cannot convert %s to INTEGER", arg.Inspect())
+				}
+				value, _ := big.NewFloat(arg.Value).Int(nil)
+				return NewInteger(value)
+			case *String:
+				value, ok := new(big.Int).SetString(arg.Value, 10)
+				if !ok {
+					return newError("could not parse %q as integer", arg.Value)
+				}
+				return NewInteger(value)
+			default:
+				return newError("argument to `int` not supported, got %s", args[0].Type())
+			}
+		}},
+	},
+	{
+		"float",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			switch arg := args[0].(type) {
+			case *Integer, *BigInteger:
+				value, _ := ToFloat(arg)
+				return &Float{Value: value}
+			case *Float:
+				return arg
+			case *String:
+				value, err := strconv.ParseFloat(arg.Value, 64)
+				if err != nil {
+					return newError("could not parse %q as float", arg.Value)
+				}
+				return &Float{Value: value}
+			default:
+				return newError("argument to `float` not supported, got %s", args[0].Type())
+			}
+		}},
+	},
+	{
+		"freeze",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			frozen, err := Freeze(args[0])
+			if err != nil {
+				return err
+			}
+			return frozen
+		}},
+	},
+	{
+		"keys",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			hash, err := hashArgument("keys", args, 1)
+			if err != nil {
+				return err
+			}
+			keys := make([]Object, 0, hash.Len())
+			for _, pair := range hash.Pairs() {
+				keys = append(keys, pair.Key)
+			}
+			return &Array{Elements: keys}
+		}},
+	},
+	{
+		"values",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			hash, err := hashArgument("values", args, 1)
+			if err != nil {
+				return err
+			}
+			values := make([]Object, 0, hash.Len())
+			for _, pair := range hash.Pairs() {
+				values = append(values, pair.Value)
+			}
+			return &Array{Elements: values}
+		}},
+	},
+	{
+		"entries",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			hash, err := hashArgument("entries", args, 1)
+			if err != nil {
+				return err
+			}
+			entries := make([]Object, 0, hash.Len())
+			for _, pair := range hash.Pairs() {
+				entries = append(entries, &Array{Elements: []Object{pair.Key, pair.Value}})
+			}
+			return &Array{Elements: entries}
+		}},
+	},
+	{
+		"has",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			hash, err := hashArgument("has", args, 2)
+			if err != nil {
+				return err
+			}
+			_, ok, err := hash.Get(args[1])
+			if err != nil {
+				return err
+			}
+			return nativeBool(ok)
+		}},
+	},
+	{
+		"delete",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			hash, err := hashArgument("delete", args, 2)
+			if err != nil {
+				return err
+			}
+			if _, err := HashKeyOf(args[1]); err != nil {
+				return err
+			}
+			result := &Hash{}
+			for _, pair := range hash.Pairs() {
+				if !Equal(pair.Key, args[1]) {
+					result.Set(pair.Key, pair.Value)
+				}
+			}
+			return result
+		}},
+	},
+	{
+		"merge",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			hash, err := hashArgument("merge", args, 2)
+			if err != nil {
+				return err
+			}
+			other, ok := args[1].(*Hash)
+			if !ok {
+				return newError("argument to `merge` must be HASH, got %s", args[1].Type())
+			}
+			result := &Hash{}
+			for _, pair := range append(hash.Pairs(), other.Pairs()...) {
+				result.Set(pair.Key, pair.Value)
+			}
+			return result
+		}},
+	},
+	{
+		"map",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			arr, fn, err := arrayAndFunctionArguments("map", args)
+			if err != nil {
+				return err
+			}
+			result := make([]Object, len(arr.Elements))
+			for i, element := range arr.Elements {
+				value := call(fn, element)
+				if isError(value) {
+					return value
+				}
+				result[i] = value
+			}
+			return &Array{Elements: result}
+		}},
+	},
+	{
+		"filter",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			arr, fn, err := arrayAndFunctionArguments("filter", args)
+			if err != nil {
+				return err
+			}
+			result := []Object{}
+			for _, element := range arr.Elements {
+				keep := call(fn, element)
+				if isError(keep) {
+					return keep
+				}
+				if isTruthy(keep) {
+					result = append(result, element)
+				}
+			}
+			return &Array{Elements: result}
+		}},
+	},
+	{
+		"reduce",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			if err := checkArgumentCount(args, 2, 3); err != nil {
+				return err
+			}
+			arr, fn, err := arrayAndFunctionArguments("reduce", args[:2])
+			if err != nil {
+				return err
+			}
+			elements := arr.Elements
+			var result Object
+			if len(args) == 3 {
+				result = args[2]
+			} else if len(elements) > 0 {
+				result, elements = elements[0], elements[1:]
+			} else {
+				return newError("reduce of empty array with no initial value")
+			}
+			for _, element := range elements {
+				result = call(fn, result, element)
+				if isError(result) {
+					return result
+				}
+			}
+			return result
+		}},
+	},
+	{
+		"find",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			arr, fn, err := arrayAndFunctionArguments("find", args)
+			if err != nil {
+				return err
+			}
+			for _, element := range arr.Elements {
+				found := call(fn, element)
+				if isError(found) {
+					return found
+				}
+				if isTruthy(found) {
+					return element
+				}
+			}
+			return nil
+		}},
+	},
+	{
+		"any",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			arr, fn, err := arrayAndFunctionArguments("any", args)
+			if err != nil {
+				return err
+			}
+			for _, element := range arr.Elements {
+				result := call(fn, element)
+				if isError(result) {
+					return result
+				}
+				if isTruthy(result) {
+					return TRUE
+				}
+			}
+			return FALSE
+		}},
+	},
+	{
+		"all",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			arr, fn, err := arrayAndFunctionArguments("all", args)
+			if err != nil {
+				return err
+			}
+			for _, element := range arr.Elements {
+				result := call(fn, element)
+				if isError(result) {
+					return result
+				}
+				if !isTruthy(result) {
+					return FALSE
+				}
+			}
+			return TRUE
+		}},
+	},
+	{
+		"sort",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			arr, err := arrayArgument("sort", args, 1, 2)
+			if err != nil {
+				return err
+			}
+			compare := compareValues
+			if len(args) == 2 {
+				fn, err := functionArgument("sort", args[1])
+				if err != nil {
+					return err
+				}
+				compare = func(a, b Object) (int, *Error) {
+					result := call(fn, a, b)
+					if err, ok := result.(*Error); ok {
+						return 0, err
+					}
+					if result.Type() != INTEGER_OBJ {
+						return 0, newError("comparator must return INTEGER, got %s", result.Type())
+					}
+					return bigValue(result).Sign(), nil
+				}
+			}
+
+			result := make([]Object, len(arr.Elements))
+			copy(result, arr.Elements)
+			var sortErr *Error
+			sort.SliceStable(result, func(i, j int) bool {
+				if sortErr != nil {
+					return false
+				}
+				order, err := compare(result[i], result[j])
+				sortErr = err
+				return order < 0
+			})
+			if sortErr != nil {
+				return sortErr
+			}
+			return &Array{Elements: result}
+		}},
+	},
+	{
+		"reverse",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			arr, err := arrayArgument("reverse", args, 1, 1)
+			if err != nil {
+				return err
+			}
+			length := len(arr.Elements)
+			result := make([]Object, length)
+			for i, element := range arr.Elements {
+				result[length-1-i] = element
+			}
+			return &Array{Elements: result}
+		}},
+	},
+	{
+		"slice",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			if err := checkArgumentCount(args, 2, 3); err != nil {
+				return err
+			}
+			var high Object
+			if len(args) == 3 {
+				high = args[2]
+			}
+			result, err := Slice(args[0], args[1], high)
+			if err != nil {
+				return err
+			}
+			return result
+		}},
+	},
+	{
+		"concat",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			result := []Object{}
+			for _, arg := range args {
+				arr, ok := arg.(*Array)
+				if !ok {
+					return newError("argument to `concat` must be ARRAY, got %s", arg.Type())
+				}
+				result = append(result, arr.Elements...)
+			}
+			return &Array{Elements: result}
+		}},
+	},
+	{
+		"range",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			if err := checkArgumentCount(args, 1, 3); err != nil {
+				return err
+			}
+			bounds := []int64{0, 0, 1} // start, end and step
+			for i, arg := range args {
+				integer, ok := arg.(*Integer)
+				if !ok {
+					return newError("argument to `range` must be INTEGER, got %s", arg.Type())
+				}
+				bounds[i] = integer.Value
+			}
+			if len(args) == 1 {
+				bounds[0], bounds[1] = 0, bounds[0]
+			}
+			start, end, step := bounds[0], bounds[1], bounds[2]
+			if step == 0 {
+				return newError("range step must not be zero")
+			}
+
+			result := []Object{}
+			for i := start; (step > 0 && i < end) || (step < 0 && i > end); i += step {
+				result = append(result, &Integer{Value: i})
+				if (step > 0 && i > math.MaxInt64-step) || (step < 0 && i < math.MinInt64-step) {
+					break
+				}
+			}
+			return &Array{Elements: result}
+		}},
+	},
+	{
+		"split",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			strs, err := stringArguments("split", args, 2)
+			if err != nil {
+				return err
+			}
+			parts := strings.Split(strs[0], strs[1])
+			result := make([]Object, len(parts))
+			for i, part := range parts {
+				result[i] = &String{Value: part}
+			}
+			return &Array{Elements: result}
+		}},
+	},
+	{
+		"join",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			arr, err := arrayArgument("join", args, 2, 2)
+			if err != nil {
+				return err
+			}
+			sep, ok := args[1].(*String)
+			if !ok {
+				return newError("argument to `join` must be STRING, got %s", args[1].Type())
+			}
+			parts := make([]string, len(arr.Elements))
+			for i, element := range arr.Elements {
+				str, ok := element.(*String)
+				if !ok {
+					return newError("elements joined by `join` must be STRING, got %s", element.Type())
+				}
+				parts[i] = str.Value
+			}
+			return &String{Value: strings.Join(parts, sep.Value)}
+		}},
+	},
+	{
+		"trim",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			strs, err := stringArguments("trim", args, 1)
+			if err != nil {
+				return err
+			}
+			return &String{Value: strings.TrimSpace(strs[0])}
+		}},
+	},
+	{
+		"upper",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			strs, err := stringArguments("upper", args, 1)
+			if err != nil {
+				return err
+			}
+			return &String{Value: strings.ToUpper(strs[0])}
+		}},
+	},
+	{
+		"lower",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			strs, err := stringArguments("lower", args, 1)
+			if err != nil {
+				return err
+			}
+			return &String{Value: strings.ToLower(strs[0])}
+		}},
+	},
+	{
+		"contains",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			strs, err := stringArguments("contains", args, 2)
+			if err != nil {
+				return err
+			}
+			return nativeBool(strings.Contains(strs[0], strs[1]))
+		}},
+	},
+	{
+		"startsWith",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			strs, err := stringArguments("startsWith", args, 2)
+			if err != nil {
+				return err
+			}
+			return nativeBool(strings.HasPrefix(strs[0], strs[1]))
+		}},
+	},
+	{
+		"endsWith",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			strs, err := stringArguments("endsWith", args, 2)
+			if err != nil {
+				return err
+			}
+			return nativeBool(strings.HasSuffix(strs[0], strs[1]))
+		}},
+	},
+	{
+		"replace",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			strs, err := stringArguments("replace", args, 3)
+			if err != nil {
+				return err
+			}
+			return &String{Value: strings.ReplaceAll(strs[0], strs[1], strs[2])}
+		}},
+	},
+	{
+		"indexOf",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			strs, err := stringArguments("indexOf", args, 2)
+			if err != nil {
+				return err
+			}
+			index := strings.Index(strs[0], strs[1])
+			if index > 0 {
+				index = utf8.RuneCountInString(strs[0][:index])
+			}
+			return &Integer{Value: int64(index)}
+		}},
+	},
+	{
+		"substr",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			if err := checkArgumentCount(args, 2, 3); err != nil {
+				return err
+			}
+			str, ok := args[0].(*String)
+			if !ok {
+				return newError("argument to `substr` must be STRING, got %s", args[0].Type())
+			}
+			if len(args) == 2 {
+				result, err := Slice(str, args[1], nil)
+				if err != nil {
+					return err
+				}
+				return result
+			}
+			start, ok := args[1].(*Integer)
+			if !ok {
+				return newError("argument to `substr` must be INTEGER, got %s", args[1].Type())
+			}
+			length, ok := args[2].(*Integer)
+			if !ok || length.Value < 0 {
+				return newError("length of `substr` must be a non-negative INTEGER, got %s", args[2].Inspect())
+			}
+			// A negative start counts from the end, resolve it before adding length.
+			sliced, err := Slice(str, start, nil)
+			if err != nil {
+				return err
+			}
+			rest := []rune(sliced.(*String).Value)
+			if length.Value < int64(len(rest)) {
+				rest = rest[:length.Value]
+			}
+			return &String{Value: string(rest)}
+		}},
+	},
+	{
+		"repeat",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			if err := checkArgumentCount(args, 2, 2); err != nil {
+				return err
+			}
+			str, ok := args[0].(*String)
+			if !ok {
+				return newError("argument to `repeat` must be STRING, got %s", args[0].Type())
+			}
+			count, ok := args[1].(*Integer)
+			if !ok {
+				return newError("argument to `repeat` must be INTEGER, got %s", args[1].Type())
+			}
+			if count.Value < 0 {
+				return newError("negative repeat count: %d", count.Value)
+			}
+			if len(str.Value) > 0 && count.Value > maxStringLength/int64(len(str.Value)) {
+				return newError("repeat count too large: %d", count.Value)
+			}
+			return &String{Value: strings.Repeat(str.Value, int(count.Value))}
+		}},
+	},
+	{
+		"format",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			if len(args) == 0 {
+				return newError("wrong number of arguments. got=0, want at least 1")
+			}
+			format, ok := args[0].(*String)
+			if !ok {
+				return newError("argument to `format` must be STRING, got %s", args[0].Type())
+			}
+			verbs, err := formatVerbs(format.Value)
+			if err != nil {
+				return err
+			}
+			if err := checkArgumentCount(args, len(verbs)+1, len(verbs)+1); err != nil {
+				return err
+			}
+			values := make([]interface{}, len(args)-1)
+			for i, arg := range args[1:] {
+				if err := checkFormatArg(verbs[i], arg); err != nil {
+					return err
+				}
+				values[i] = formatArg{arg}
+			}
+			return &String{Value: fmt.Sprintf(format.Value, values...)}
+		}},
+	},
+	{
+		"zip",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			if len(args) == 0 {
+				return &Array{Elements: []Object{}}
+			}
+			arrays := make([]*Array, len(args))
+			length := math.MaxInt64
+			for i, arg := range args {
+				arr, ok := arg.(*Array)
+				if !ok {
+					return newError("argument to `zip` must be ARRAY, got %s", arg.Type())
+				}
+				arrays[i] = arr
+				if len(arr.Elements) < length {
+					length = len(arr.Elements)
+				}
+			}
+			result := make([]Object, length)
+			for i := range result {
+				tuple := make([]Object, len(arrays))
+				for j, arr := range arrays {
+					tuple[j] = arr.Elements[i]
+				}
+				result[i] = &Array{Elements: tuple}
+			}
+			return &Array{Elements: result}
+		}},
+	},
+	{
+		"bytes",
+		&Builtin{Fn: func(call CallFunction, args ...Object) Object {
+			strs, err := stringArguments("bytes", args, 1)
+			if err != nil {
+				return err
+			}
+			result := make([]Object, len(strs[0]))
+			for i := 0; i < len(strs[0]); i++ {
+				result[i] = &Integer{Value: int64(strs[0][i])}
+			}
+			return &Array{Elements: result}
+		}},
+	},
+}
+
+// GetBuiltinByName returns builtin function with the given name or nil.
+func GetBuiltinByName(name string) *Builtin {
+	for _, def := range Builtins {
+		if def.Name == name {
+			return def.Builtin
+		}
+	}
+	return nil
+}
+
+// GetBuiltinName returns the name of builtin.
+func GetBuiltinName(builtin *Builtin) string {
+	for _, def := range Builtins {
+		if def.Builtin == builtin {
+			return def.Name
+		}
+	}
+	return "<builtin>"
+}
+
+// checkArgumentCount checks that a builtin got between min and max arguments.
+func checkArgumentCount(args []Object, min, max int) *Error {
+	if len(args) >= min && len(args) <= max {
+		return nil
+	}
+	if min == max {
+		return newError("wrong number of arguments. got=%d, want=%d", len(args), min)
+	}
+	return newError("wrong number of arguments. got=%d, want=%d to %d", len(args), min, max)
+}
+
+// hashArgument checks that a builtin got want arguments, the first of which
+// is a hash, and returns the hash.
+func hashArgument(name string, args []Object, want int) (*Hash, *Error) {
+	if err := checkArgumentCount(args, want, want); err != nil {
+		return nil, err
+	}
+	hash, ok := args[0].(*Hash)
+	if !ok {
+		return nil, newError("argument to `%s` must be HASH, got %s", name, args[0].Type())
+	}
+	return hash, nil
+}
+
+// maxStringLength limits the size of strings built by repeat, which could
+// otherwise exhaust memory.
+const maxStringLength = 1 << 30
+
+// stringArguments checks that a builtin got count arguments, all of which are
+// strings, and returns their values.
+func stringArguments(name string, args []Object, count int) ([]string, *Error) {
+	if err := checkArgumentCount(args, count, count); err != nil {
+		return nil, err
+	}
+	strs := make([]string, count)
+	for i, arg := range args {
+		str, ok := arg.(*String)
+		if !ok {
+			return nil, newError("argument to `%s` must be STRING, got %s", name, arg.Type())
+		}
+		strs[i] = str.Value
+	}
+	return strs, nil
+}
+
+// formatTypes maps the verbs supported by format to the types of arguments
+// they accept, or nil if they accept any.
+var formatTypes = map[rune][]Type{
+	's': nil, 'v': nil, 'q': nil,
+	'd': {INTEGER_OBJ}, 'b': {INTEGER_OBJ}, 'o': {INTEGER_OBJ},
+	'c': {INTEGER_OBJ}, 'U': {INTEGER_OBJ},
+	'x': {INTEGER_OBJ, FLOAT_OBJ, STRING_OBJ}, 'X': {INTEGER_OBJ, FLOAT_OBJ, STRING_OBJ},
+	'e': {FLOAT_OBJ, INTEGER_OBJ}, 'E': {FLOAT_OBJ, INTEGER_OBJ},
+	'f': {FLOAT_OBJ, INTEGER_OBJ}, 'F': {FLOAT_OBJ, INTEGER_OBJ},
+	'g': {FLOAT_OBJ, INTEGER_OBJ}, 'G': {FLOAT_OBJ, INTEGER_OBJ},
+	't': {BOOLEAN_OBJ},
+}
+
+// formatVerbs returns the verbs of the directives in format, one for each
+// argument they format. %% formats no argument.
+func formatVerbs(format string) ([]rune, *Error) {
+	var verbs []rune
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		start := i
+		for i++; i < len(format) && strings.IndexByte("+-# 0123456789.", format[i]) >= 0; i++ {
+		}
+		if i == len(format) {
+			return nil, newError("missing verb at end of format: %s", format[start:])
+		}
+		verb, size := utf8.DecodeRuneInString(format[i:])
+		i += size - 1
+		if verb == '%' {
+			continue
+		}
+		if _, ok := formatTypes[verb]; !ok {
+			return nil, newError("unsupported verb in format: %s", format[start:i+1])
+		}
+		verbs = append(verbs, verb)
+	}
+	return verbs, nil
+}
+
+// checkFormatArg checks that arg can be formatted with verb.
+func checkFormatArg(verb rune, arg Object) *Error {
+	types := formatTypes[verb]
+	if types == nil {
+		return nil
+	}
+	names := make([]string, len(types))
+	for i, t := range types {
+		if arg.Type() == t {
+			return nil
+		}
+		names[i] = string(t)
+	}
+	return newError("argument to `format` must be %s, got %s", strings.Join(names, " or "), arg.Type())
+}
+
+// formatArg is an argument of the format builtin. It formats Monkey values
+// with %s and %v the same as puts prints them, and with other verbs such as
+// %d, %.2f, %q or %x the same as Go formats their values.
+type formatArg struct {
+	obj Object
+}
+
+func (a formatArg) Format(f fmt.State, verb rune) {
+	if verb == 's' || verb == 'v' {
+		fmt.Fprintf(f, formatDirective(f, 's'), a.obj.Inspect())
+		return
+	}
+
+	var value interface{}
+	switch obj := a.obj.(type) {
+	case *Integer:
+		value = obj.Value
+		if strings.ContainsRune("eEfFgG", verb) {
+			value = float64(obj.Value)
+		}
+	case *BigInteger:
+		switch {
+		case strings.ContainsRune("eEfFgG", verb):
+			value, _ = ToFloat(obj)
+		case verb == 'c' || verb == 'U':
+			value = utf8.RuneError // too big to be a character
+		default:
+			value = obj.Value
+		}
+	case *Float:
+		value = obj.Value
+	case *Boolean:
+		value = obj.Value
+	case *String:
+		value = obj.Value
+	default:
+		value = obj.Inspect()
+	}
+	fmt.Fprintf(f, formatDirective(f, verb), value)
+}
+
+// formatDirective rebuilds the directive being formatted with the given verb.
+func formatDirective(f fmt.State, verb rune) string {
+	var directive strings.Builder
+	directive.WriteByte('%')
+	for _, flag := range "+-# 0" {
+		if f.Flag(int(flag)) {
+			directive.WriteRune(flag)
+		}
+	}
+	if width, ok := f.Width(); ok {
+		fmt.Fprintf(&directive, "%d", width)
+	}
+	if precision, ok := f.Precision(); ok {
+		fmt.Fprintf(&directive, ".%d", precision)
+	}
+	directive.WriteRune(verb)
+	return directive.String()
+}
+
+// arrayArgument checks that a builtin got between min and max arguments, the
+// first of which is an array, and returns the array.
+func arrayArgument(name string, args []Object, min, max int) (*Array, *Error) {
+	if err := checkArgumentCount(args, min, max); err != nil {
+		return nil, err
+	}
+	arr, ok := args[0].(*Array)
+	if !ok {
+		return nil, newError("argument to `%s` must be ARRAY, got %s", name, args[0].Type())
+	}
+	return arr, nil
+}
+
+// functionArgument checks that arg of a builtin can be called.
+func functionArgument(name string, arg Object) (Object, *Error) {
+	if arg.Type() != FUNCTION_OBJ && arg.Type() != BUILTIN_OBJ {
+		return nil, newError("argument to `%s` must be FUNCTION, got %s", name, arg.Type())
+	}
+	return arg, nil
+}
+
+// arrayAndFunctionArguments checks the arguments of builtins which call a
+// function for elements of an array, and returns the array and the function.
+func arrayAndFunctionArguments(name string, args []Object) (*Array, Object, *Error) {
+	arr, err := arrayArgument(name, args, 2, 2)
+	if err != nil {
+		return nil, nil, err
+	}
+	fn, err := functionArgument(name, args[1])
+	if err != nil {
+		return nil, nil, err
+	}
+	return arr, fn, nil
+}
+
+// compareValues orders numbers by value and strings lexicographically, the
+// same as the < operator.
+func compareValues(a, b Object) (int, *Error) {
+	switch {
+	case a.Type() == INTEGER_OBJ && b.Type() == INTEGER_OBJ:
+		return CompareIntegers(a, b), nil
+	case a.Type() == STRING_OBJ && b.Type() == STRING_OBJ:
+		return strings.Compare(a.(*String).Value, b.(*String).Value), nil
+	}
+	x, xok := ToFloat(a)
+	y, yok := ToFloat(b)
+	if !xok || !yok {
+		if a.Type() == b.Type() {
+			return 0, newError("unknown operator: %s < %s", a.Type(), b.Type())
+		}
+		return 0, newError("type mismatch: %s < %s", a.Type(), b.Type())
+	}
+	switch {
+	case x < y:
+		return -1, nil
+	case x > y:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+// isTruthy follows the engines: everything but false and null is true.
+func isTruthy(obj Object) bool {
+	switch obj := obj.(type) {
+	case *Boolean:
+		return obj.Value
+	case *Null:
+		return false
+	default:
+		return true
+	}
+}
+
+func isError(obj Object) bool {
+	_, ok := obj.(*Error)
+	return ok
+}
+
+func newError(format string, a ...interface{}) *Error {
+	return &Error{Message: fmt.Sprintf(format, a...)}
+}
@@ -1,13 +1,17 @@
 package object
 
 type Environment struct {
-	store map[string]Object
-	outer *Environment
+	store    map[string]Object
+	consts   map[string]bool // names bound by const, which can't be changed
+	outer    *Environment
+	depth    int
+	loop     bool   // set for the environment of an iteration of a loop
+	variable string // variable of the for-in loop, for loop environments
 }
 
 func NewEnvironment() *Environment {
 	s := make(map[string]Object)
-	return &Environment{store: s, outer: nil}
+	return &Environment{store: s, consts: make(map[string]bool), outer: nil}
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
@@ -18,9 +22,46 @@ func (e *Environment) Get(name string) (Object, bool) {
 	return obj, ok
 }
 
-func (e *Environment) Set(name string, val Object) Object {
+// Set binds name to val in this environment, unless name is a constant.
+func (e *Environment) Set(name string, val Object) *Error {
+	if e.loop && name != e.variable && !e.consts[name] {
+		return e.outer.Set(name, val)
+	}
+	if e.consts[name] {
+		return newError("cannot assign to constant: %s", name)
+	}
+	e.store[name] = val
+	return nil
+}
+
+// SetConst binds name to val in this environment for good.
+func (e *Environment) SetConst(name string, val Object) *Error {
+	if e.constant(name) {
+		return newError("cannot assign to constant: %s", name)
+	}
 	e.store[name] = val
-	return val
+	e.consts[name] = true
+	return nil
+}
+
+// constant reports whether name is a constant which a declaration in this
+// environment would rebind.
+func (e *Environment) constant(name string) bool {
+	if e.consts[name] {
+		return true
+	}
+	return e.loop && name != e.variable && e.outer.constant(name)
+}
+
+// Assign changes the value of an existing variable in the innermost
+// environment which defines it. Constants can't be changed.
+func (e *Environment) Assign(name string, val Object) *Error {
+	for env := e; env != nil; env = env.outer {
+		if _, ok := env.store[name]; ok {
+			return env.Set(name, val)
+		}
+	}
+	return newError("identifier not found: %s", name)
 }
 
 func NewEnclosedEnvironment(outer *Environment) *Environment {
@@ -28,3 +69,34 @@ func NewEnclosedEnvironment(outer *Environment) *Environment {
 	env.outer = outer
 	return env
 }
+
+// NewCallEnvironment creates the environment of a function call which is
+// depth calls deep. Variables are looked up in outer, the environment the
+// function closes over, which has nothing to do with its caller.
+func NewCallEnvironment(outer *Environment, depth int) *Environment {
+	env := NewEnclosedEnvironment(outer)
+	env.depth = depth
+	return env
+}
+
+// NewLoopEnvironment creates the environment of an iteration of a loop. It
+// holds the variable of a for-in loop, unless variable is empty, and the
+// constants declared in the loop body, so that they don't outlive the loop
+// and each iteration, and closures made in it, has its own. Variables
+// declared with let in the loop body are declared in outer. The loop
+// variable can't hide a constant of outer.
+func NewLoopEnvironment(outer *Environment, variable string) (*Environment, *Error) {
+	if variable != "" && outer.constant(variable) {
+		return nil, newError("cannot assign to constant: %s", variable)
+	}
+	env := NewEnclosedEnvironment(outer)
+	env.depth = outer.depth
+	env.loop = true
+	env.variable = variable
+	return env, nil
+}
+
+// Depth returns the number of function calls the environment is nested in.
+func (e *Environment) Depth() int {
+	return e.depth
+}
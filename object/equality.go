@@ -0,0 +1,91 @@
+package object
+
+// Equal reports whether a and b are equal values. Numbers are equal if they
+// have the same value, whatever their type, arrays and hashes if they have
+// equal elements, and functions only to themselves. Values which contain
+// themselves are equal if they are equal wherever they don't.
+func Equal(a, b Object) bool {
+	return equal(a, b, nil)
+}
+
+// comparison is a pair of collections being compared.
+type comparison [2]Object
+
+// equal is Equal, which assumes that the collections in comparing are equal,
+// as they are compared already further up.
+func equal(a, b Object, comparing map[comparison]bool) bool {
+	switch a := a.(type) {
+	case *Integer, *BigInteger, *Float:
+		return numbersEqual(a, b)
+	case *String:
+		b, ok := b.(*String)
+		return ok && a.Value == b.Value
+	case *Boolean:
+		b, ok := b.(*Boolean)
+		return ok && a.Value == b.Value
+	case *Null:
+		_, ok := b.(*Null)
+		return ok
+	case *Array:
+		b, ok := b.(*Array)
+		if a == b {
+			return true
+		}
+		if !ok || len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		if comparing = compare(a, b, comparing); comparing == nil {
+			return true
+		}
+		for i, element := range a.Elements {
+			if !equal(element, b.Elements[i], comparing) {
+				return false
+			}
+		}
+		return true
+	case *Hash:
+		b, ok := b.(*Hash)
+		if a == b {
+			return true
+		}
+		if !ok || a.Len() != b.Len() {
+			return false
+		}
+		if comparing = compare(a, b, comparing); comparing == nil {
+			return true
+		}
+		for _, pair := range a.Pairs() {
+			other, ok, _ := b.Get(pair.Key)
+			if !ok || !equal(pair.Value, other, comparing) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+// compare adds the comparison of a and b to comparing, and returns it, or
+// nil if a and b are being compared already.
+func compare(a, b Object, comparing map[comparison]bool) map[comparison]bool {
+	if comparing == nil {
+		comparing = make(map[comparison]bool)
+	} else if comparing[comparison{a, b}] {
+		return nil
+	}
+	comparing[comparison{a, b}] = true
+	return comparing
+}
+
+func numbersEqual(a, b Object) bool {
+	if a.Type() == INTEGER_OBJ && b.Type() == INTEGER_OBJ {
+		return CompareIntegers(a, b) == 0
+	}
+	x, ok := ToFloat(a)
+	if !ok {
+		return false
+	}
+	y, ok := ToFloat(b)
+	return ok && x == y
+}
@@ -0,0 +1,185 @@
+package object
+
+import (
+	"encoding/binary"
+	"hash"
+	"hash/fnv"
+)
+
+// HashKeyOf returns the HashKey obj is stored under in a hash. Arrays and
+// hashes are hashed by their contents, so they are usable as keys as long as
+// all their elements are, and they don't contain themselves.
+func HashKeyOf(obj Object) (HashKey, *Error) {
+	return hashKeyOf(obj, nil)
+}
+
+// hashKeyOf is HashKeyOf, where path holds the collections obj is in.
+func hashKeyOf(obj Object, path map[Object]bool) (HashKey, *Error) {
+	switch obj := obj.(type) {
+	case Hashable:
+		return obj.HashKey(), nil
+	case *Array:
+		if path = enter(obj, path); path == nil {
+			return HashKey{}, newError("unusable as hash key: %s", obj.Type())
+		}
+		defer delete(path, obj)
+		h := fnv.New64a()
+		for _, element := range obj.Elements {
+			key, err := hashKeyOf(element, path)
+			if err != nil {
+				return HashKey{}, err
+			}
+			writeHashKey(h, key)
+		}
+		return HashKey{Type: obj.Type(), Value: h.Sum64()}, nil
+	case *Hash:
+		if path = enter(obj, path); path == nil {
+			return HashKey{}, newError("unusable as hash key: %s", obj.Type())
+		}
+		defer delete(path, obj)
+		// Hashes of the pairs are summed, so the order of pairs doesn't matter.
+		var sum uint64
+		for _, pair := range obj.Pairs() {
+			key, err := hashKeyOf(pair.Key, path)
+			if err != nil {
+				return HashKey{}, err
+			}
+			value, err := hashKeyOf(pair.Value, path)
+			if err != nil {
+				return HashKey{}, err
+			}
+			h := fnv.New64a()
+			writeHashKey(h, key)
+			writeHashKey(h, value)
+			sum += h.Sum64()
+		}
+		return HashKey{Type: obj.Type(), Value: sum}, nil
+	default:
+		return HashKey{}, newError("unusable as hash key: %s", obj.Type())
+	}
+}
+
+// enter adds collection to path, the collections being walked through, and
+// returns it, or nil if collection is in path already, as it contains itself.
+func enter(collection Object, path map[Object]bool) map[Object]bool {
+	if path == nil {
+		path = make(map[Object]bool)
+	} else if path[collection] {
+		return nil
+	}
+	path[collection] = true
+	return path
+}
+
+func writeHashKey(h hash.Hash64, key HashKey) {
+	var value [8]byte
+	binary.LittleEndian.PutUint64(value[:], key.Value)
+	h.Write([]byte(key.Type))
+	h.Write(value[:])
+}
+
+// Get returns the value stored under key. It reports false if there is no
+// such key, and an error if key isn't usable as a hash key.
+func (h *Hash) Get(key Object) (Object, bool, *Error) {
+	hashKey, err := HashKeyOf(key)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, i := range h.buckets[hashKey] {
+		if Equal(h.pairs[i].Key, key) {
+			return h.pairs[i].Value, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// Set stores value under key, replacing the value of an equal key if there is
+// one. Keys are stored frozen, so changing an array used as a key afterwards
+// doesn't change the hash.
+func (h *Hash) Set(key, value Object) *Error {
+	if h.Frozen {
+		return newError("cannot modify frozen %s", h.Type())
+	}
+	hashKey, err := HashKeyOf(key)
+	if err != nil {
+		return err
+	}
+	if h.buckets == nil {
+		h.buckets = make(map[HashKey][]int)
+	}
+
+	for _, i := range h.buckets[hashKey] {
+		if Equal(h.pairs[i].Key, key) {
+			h.pairs[i].Value = value
+			return nil
+		}
+	}
+	// key has a hash key, so it doesn't contain itself and can be frozen.
+	frozen, _ := Freeze(key)
+	h.buckets[hashKey] = append(h.buckets[hashKey], len(h.pairs))
+	h.pairs = append(h.pairs, HashPair{Key: frozen, Value: value})
+	return nil
+}
+
+// Len returns the number of pairs in the hash.
+func (h *Hash) Len() int {
+	return len(h.pairs)
+}
+
+// Pairs returns the pairs of the hash in the order their keys were added.
+func (h *Hash) Pairs() []HashPair {
+	pairs := make([]HashPair, len(h.pairs))
+	copy(pairs, h.pairs)
+	return pairs
+}
+
+// Freeze returns obj in a form which can't be changed. Arrays and hashes are
+// copied with all their elements frozen, unless they are frozen already.
+// Other values can't be changed anyway and are returned as they are. Values
+// which contain themselves can't be frozen.
+func Freeze(obj Object) (Object, *Error) {
+	return freeze(obj, nil)
+}
+
+// freeze is Freeze, where path holds the collections obj is in.
+func freeze(obj Object, path map[Object]bool) (Object, *Error) {
+	switch obj := obj.(type) {
+	case *Array:
+		if obj.Frozen {
+			return obj, nil
+		}
+		if path = enter(obj, path); path == nil {
+			return nil, newError("cannot freeze cyclic value: %s", obj.Type())
+		}
+		defer delete(path, obj)
+		elements := make([]Object, len(obj.Elements))
+		for i, element := range obj.Elements {
+			frozen, err := freeze(element, path)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = frozen
+		}
+		return &Array{Elements: elements, Frozen: true}, nil
+	case *Hash:
+		if obj.Frozen {
+			return obj, nil
+		}
+		if path = enter(obj, path); path == nil {
+			return nil, newError("cannot freeze cyclic value: %s", obj.Type())
+		}
+		defer delete(path, obj)
+		frozen := &Hash{}
+		for _, pair := range obj.Pairs() {
+			value, err := freeze(pair.Value, path)
+			if err != nil {
+				return nil, err
+			}
+			frozen.Set(pair.Key, value)
+		}
+		frozen.Frozen = true
+		return frozen, nil
+	default:
+		return obj, nil
+	}
+}
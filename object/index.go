@@ -0,0 +1,106 @@
+package object
+
+import "math/big"
+
+// SetIndex stores value in the element of an array or the entry of a hash
+// at index. Arrays can't grow this way, so index has to be in range. Frozen
+// arrays and hashes can't be changed at all.
+func SetIndex(collection, index, value Object) *Error {
+	switch collection := collection.(type) {
+	case *Array:
+		if collection.Frozen {
+			return newError("cannot modify frozen %s", collection.Type())
+		}
+		if index.Type() != INTEGER_OBJ {
+			return newError("array index must be INTEGER, got %s", index.Type())
+		}
+		i, ok := index.(*Integer)
+		if !ok || i.Value < 0 || i.Value >= int64(len(collection.Elements)) {
+			return newError("index out of range: %s", index.Inspect())
+		}
+		collection.Elements[i.Value] = value
+	case *Hash:
+		return collection.Set(index, value)
+	default:
+		return newError("index assignment not supported: %s", collection.Type())
+	}
+	return nil
+}
+
+// StringIndex returns the character (Unicode code point) of a string at
+// index, or false if the index is out of range.
+func StringIndex(str *String, index Object) (Object, bool) {
+	i, ok := index.(*Integer)
+	if !ok || i.Value < 0 {
+		return nil, false
+	}
+	n := int64(0)
+	for _, ch := range str.Value {
+		if n == i.Value {
+			return &String{Value: string(ch)}, true
+		}
+		n++
+	}
+	return nil, false
+}
+
+// Slice returns a new array or string with the elements or characters of
+// collection from low up to high. Omitted bounds, which are nil or null,
+// select the start and the end. Negative bounds count from the end, and
+// bounds out of range are clamped, so slicing never fails because of the
+// length.
+func Slice(collection, low, high Object) (Object, *Error) {
+	var length int
+	var chars []rune
+	switch collection := collection.(type) {
+	case *Array:
+		length = len(collection.Elements)
+	case *String:
+		chars = []rune(collection.Value)
+		length = len(chars)
+	default:
+		return nil, newError("slice operator not supported: %s", collection.Type())
+	}
+
+	start, err := sliceBound(low, 0, length)
+	if err != nil {
+		return nil, err
+	}
+	end, err := sliceBound(high, length, length)
+	if err != nil {
+		return nil, err
+	}
+	if start > end {
+		start = end
+	}
+
+	if arr, ok := collection.(*Array); ok {
+		elements := make([]Object, end-start)
+		copy(elements, arr.Elements[start:end])
+		return &Array{Elements: elements}, nil
+	}
+	return &String{Value: string(chars[start:end])}, nil
+}
+
+// sliceBound returns bound as an index in a sequence of the given length, or
+// def if it is omitted.
+func sliceBound(bound Object, def, length int) (int, *Error) {
+	if bound == nil || bound.Type() == NULL_OBJ {
+		return def, nil
+	}
+	if bound.Type() != INTEGER_OBJ {
+		return 0, newError("slice index must be INTEGER, got %s", bound.Type())
+	}
+	index := bigValue(bound)
+	if index.Sign() < 0 {
+		index = new(big.Int).Add(index, big.NewInt(int64(length)))
+	}
+	switch {
+	case index.Sign() < 0:
+		return 0, nil
+	case index.Cmp(big.NewInt(int64(length))) > 0:
+		return length, nil
+	default:
+		return int(index.Int64()), nil
+	}
+}
@@ -0,0 +1,28 @@
+package object
+
+// Iterate returns the values a for-in loop over obj goes through: elements
+// of an array, characters of a string or keys of a hash in insertion order.
+// It reports false if obj can't be iterated over.
+func Iterate(obj Object) ([]Object, bool) {
+	switch obj := obj.(type) {
+	case *Array:
+		// Copy, so changes made by the loop body don't affect the loop.
+		elements := make([]Object, len(obj.Elements))
+		copy(elements, obj.Elements)
+		return elements, true
+	case *String:
+		var chars []Object
+		for _, r := range obj.Value {
+			chars = append(chars, &String{Value: string(r)})
+		}
+		return chars, true
+	case *Hash:
+		keys := make([]Object, 0, obj.Len())
+		for _, pair := range obj.Pairs() {
+			keys = append(keys, pair.Key)
+		}
+		return keys, true
+	default:
+		return nil, false
+	}
+}
@@ -4,24 +4,34 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"math"
+	"math/big"
+	"strconv"
 	"strings"
 
 	"github.com/idexter/monkey/ast"
+	"github.com/idexter/monkey/code"
+	"github.com/idexter/monkey/token"
 )
 
 type Type string
 
 const (
 	INTEGER_OBJ      = "INTEGER"
+	FLOAT_OBJ        = "FLOAT"
 	BOOLEAN_OBJ      = "BOOLEAN"
 	NULL_OBJ         = "NULL"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
+	BREAK_OBJ        = "BREAK"
+	CONTINUE_OBJ     = "CONTINUE"
 	ERROR_OBJ        = "ERROR"
 	FUNCTION_OBJ     = "FUNCTION"
 	STRING_OBJ       = "STRING"
 	BUILTIN_OBJ      = "BUILTIN"
 	ARRAY_OBJ        = "ARRAY"
 	HASH_OBJ         = "HASH"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
 )
 
 type Object interface {
@@ -36,6 +46,30 @@ type Integer struct {
 func (i *Integer) Inspect() string { return fmt.Sprintf("%d", i.Value) }
 func (i *Integer) Type() Type      { return INTEGER_OBJ }
 
+// BigInteger is an integer which doesn't fit in int64. Arithmetic promotes
+// integers to BigInteger on overflow and demotes them back when the result
+// fits, so the same value never has both representations.
+type BigInteger struct {
+	Value *big.Int
+}
+
+func (bi *BigInteger) Inspect() string { return bi.Value.String() }
+func (bi *BigInteger) Type() Type      { return INTEGER_OBJ }
+
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() Type { return FLOAT_OBJ }
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	// Keep whole floats apart from integers.
+	if strings.IndexFunc(s, func(r rune) bool { return r != '-' && (r < '0' || r > '9') }) < 0 {
+		s += ".0"
+	}
+	return s
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -43,6 +77,20 @@ type Boolean struct {
 func (b *Boolean) Inspect() string { return fmt.Sprintf("%t", b.Value) }
 func (b *Boolean) Type() Type      { return BOOLEAN_OBJ }
 
+// TRUE and FALSE are the only Boolean values the engines and builtins create,
+// so booleans can be told apart by identity.
+var (
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+)
+
+func nativeBool(value bool) *Boolean {
+	if value {
+		return TRUE
+	}
+	return FALSE
+}
+
 type Null struct{}
 
 func (n *Null) Inspect() string { return "null" }
@@ -55,14 +103,67 @@ type ReturnValue struct {
 func (rv *ReturnValue) Inspect() string { return rv.Value.Inspect() }
 func (rv *ReturnValue) Type() Type      { return RETURN_VALUE_OBJ }
 
+// Break is the result of a break statement, which unwinds the statements
+// of a loop body up to the loop.
+type Break struct{}
+
+func (b *Break) Inspect() string { return "break" }
+func (b *Break) Type() Type      { return BREAK_OBJ }
+
+// Continue is the result of a continue statement, which unwinds the
+// statements of a loop body up to the loop.
+type Continue struct{}
+
+func (c *Continue) Inspect() string { return "continue" }
+func (c *Continue) Type() Type      { return CONTINUE_OBJ }
+
 type Error struct {
 	Message string
+	Pos     token.Position // where the error occurred
+	Stack   []Frame        // calls the error unwound through, the innermost first
+}
+
+func (e *Error) Type() Type    { return ERROR_OBJ }
+func (e *Error) Error() string { return e.Message }
+func (e *Error) Inspect() string {
+	if e.Pos.IsValid() {
+		return "ERROR: " + e.Pos.String() + ": " + e.Message
+	}
+	return "ERROR: " + e.Message
+}
+
+// StackTrace returns the call stack of the error, one frame per line.
+// Runs of the same frame, as left by deep recursion, are collapsed.
+func (e *Error) StackTrace() string {
+	var out bytes.Buffer
+	for i := 0; i < len(e.Stack); {
+		f := e.Stack[i]
+		out.WriteString("\tin " + f.String() + "\n")
+
+		n := 1
+		for i+n < len(e.Stack) && e.Stack[i+n] == f {
+			n++
+		}
+		if n > 1 {
+			fmt.Fprintf(&out, "\t... repeated %d more times\n", n-1)
+		}
+		i += n
+	}
+	return out.String()
 }
 
-func (e *Error) Inspect() string { return "ERROR: " + e.Message }
-func (e *Error) Type() Type      { return ERROR_OBJ }
+// Frame describes a function call.
+type Frame struct {
+	Function string         // name of the called function
+	Pos      token.Position // position of the call
+}
+
+func (f Frame) String() string {
+	return fmt.Sprintf("%s called at %s", f.Function, f.Pos)
+}
 
 type Function struct {
+	Name       string
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
@@ -86,6 +187,34 @@ func (f *Function) Inspect() string {
 	return out.String()
 }
 
+// CompiledFunction holds bytecode of a function literal.
+type CompiledFunction struct {
+	Name          string
+	Instructions  code.Instructions
+	Positions     map[int]token.Position // source positions of the instructions
+	Names         map[int]string         // names of the variables the instructions read
+	NumLocals     int
+	NumParameters int
+}
+
+func (cf *CompiledFunction) Type() Type { return COMPILED_FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// Closure is a compiled function together with the free variables it refers to.
+// It is what the VM's functions are at runtime, so it reports FUNCTION as its
+// type to keep error messages the same as in the evaluator.
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+func (c *Closure) Type() Type { return FUNCTION_OBJ }
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
 type String struct {
 	Value string
 }
@@ -93,7 +222,21 @@ type String struct {
 func (s *String) Type() Type      { return STRING_OBJ }
 func (s *String) Inspect() string { return s.Value }
 
-type BuiltinFunction func(args ...Object) Object
+// Interpolate joins the parts of an interpolated string: strings as they are
+// and other values the way Inspect formats them.
+func Interpolate(parts []Object) *String {
+	var out strings.Builder
+	for _, part := range parts {
+		out.WriteString(part.Inspect())
+	}
+	return &String{Value: out.String()}
+}
+
+// CallFunction calls a function with args and returns its result. Engines
+// pass it to builtins, so that builtins can call functions of either engine.
+type CallFunction func(fn Object, args ...Object) Object
+
+type BuiltinFunction func(call CallFunction, args ...Object) Object
 
 type Builtin struct {
 	Fn BuiltinFunction
@@ -104,15 +247,27 @@ func (b *Builtin) Inspect() string { return "builtin function" }
 
 type Array struct {
 	Elements []Object
+	Frozen   bool // set for arrays which can't be changed anymore
 }
 
 func (ao *Array) Type() Type { return ARRAY_OBJ }
 
 func (ao *Array) Inspect() string {
+	return ao.inspect(nil)
+}
+
+// inspect is Inspect, where path holds the collections the array is in. An
+// array which contains itself is printed as [...] where it appears again.
+func (ao *Array) inspect(path map[Object]bool) string {
+	if path = enter(ao, path); path == nil {
+		return "[...]"
+	}
+	defer delete(path, ao)
+
 	var out bytes.Buffer
 	elements := []string{}
 	for _, e := range ao.Elements {
-		elements = append(elements, e.Inspect())
+		elements = append(elements, inspect(e, path))
 	}
 	out.WriteString("[")
 	out.WriteString(strings.Join(elements, ", "))
@@ -138,6 +293,21 @@ func (b *Boolean) HashKey() HashKey {
 func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
+func (bi *BigInteger) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write([]byte(bi.Value.String()))
+	return HashKey{Type: bi.Type(), Value: h.Sum64()}
+}
+
+// HashKey of a float with an integer value is the key of that integer, so
+// 1.0 and 1 are the same key, the same as they are equal.
+func (f *Float) HashKey() HashKey {
+	if f.Value == math.Trunc(f.Value) && !math.IsInf(f.Value, 0) {
+		v, _ := new(big.Float).SetFloat64(f.Value).Int(nil)
+		return NewInteger(v).(Hashable).HashKey()
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
@@ -149,18 +319,34 @@ type HashPair struct {
 	Value Object
 }
 
+// Hash maps keys to values and remembers the order keys were added in. It is
+// used through Get and Set, which find keys by their HashKey and tell apart
+// keys with colliding HashKey using Equal.
 type Hash struct {
-	Pairs map[HashKey]HashPair
+	pairs   []HashPair        // in insertion order
+	buckets map[HashKey][]int // indexes of pairs by the HashKey of their keys
+	Frozen  bool              // set for hashes which can't be changed anymore
 }
 
 func (h *Hash) Type() Type { return HASH_OBJ }
 func (h *Hash) Inspect() string {
+	return h.inspect(nil)
+}
+
+// inspect is Inspect, where path holds the collections the hash is in. A
+// hash which contains itself is printed as {...} where it appears again.
+func (h *Hash) inspect(path map[Object]bool) string {
+	if path = enter(h, path); path == nil {
+		return "{...}"
+	}
+	defer delete(path, h)
+
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for _, pair := range h.Pairs {
+	for _, pair := range h.Pairs() {
 		pairs = append(pairs, fmt.Sprintf("%s: %s",
-			pair.Key.Inspect(), pair.Value.Inspect()))
+			inspect(pair.Key, path), inspect(pair.Value, path)))
 	}
 
 	out.WriteString("{")
@@ -170,6 +356,18 @@ func (h *Hash) Inspect() string {
 	return out.String()
 }
 
+// inspect returns obj.Inspect(), where path holds the collections obj is in.
+func inspect(obj Object, path map[Object]bool) string {
+	switch obj := obj.(type) {
+	case *Array:
+		return obj.inspect(path)
+	case *Hash:
+		return obj.inspect(path)
+	default:
+		return obj.Inspect()
+	}
+}
+
 type Hashable interface {
 	HashKey() HashKey
 }
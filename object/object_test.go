@@ -1,6 +1,12 @@
 package object
 
-import "testing"
+import (
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/idexter/monkey/token"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -18,3 +24,396 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have same hash keys")
 	}
 }
+
+func TestErrorStackTrace(t *testing.T) {
+	f := Frame{Function: "f", Pos: token.Position{Line: 1, Column: 21}}
+	err := &Error{
+		Message: "maximum recursion depth exceeded calling f",
+		Stack: []Frame{
+			f, f, f,
+			{Function: "f", Pos: token.Position{Line: 2, Column: 16}},
+			{Function: "g", Pos: token.Position{Line: 3, Column: 1}},
+		},
+	}
+
+	expected := "\tin f called at 1:21\n" +
+		"\t... repeated 2 more times\n" +
+		"\tin f called at 2:16\n" +
+		"\tin g called at 3:1\n"
+	if trace := err.StackTrace(); trace != expected {
+		t.Errorf("wrong stack trace. want=%q, got=%q", expected, trace)
+	}
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []struct {
+		operator string
+		left     int64
+		right    int64
+		expected string
+		overflow bool
+	}{
+		{"+", math.MaxInt64, 1, "9223372036854775808", true},
+		{"+", math.MinInt64, -1, "-9223372036854775809", true},
+		{"+", math.MaxInt64, math.MinInt64, "-1", false},
+		{"-", math.MinInt64, 1, "-9223372036854775809", true},
+		{"-", 0, math.MinInt64, "9223372036854775808", true},
+		{"-", -1, math.MinInt64, "9223372036854775807", false},
+		{"*", math.MaxInt64, 2, "18446744073709551614", true},
+		{"*", -1, math.MinInt64, "9223372036854775808", true},
+		{"*", math.MinInt64, -1, "9223372036854775808", true},
+		{"*", math.MinInt64, 1, "-9223372036854775808", false},
+		{"*", 0, math.MinInt64, "0", false},
+		{"/", 7, -2, "-3", false},
+		{"/", math.MinInt64, -1, "9223372036854775808", true},
+		{"%", 7, -2, "1", false},
+		{"%", -7, 2, "-1", false},
+		{"%", math.MinInt64, -1, "0", false},
+		{"**", 2, 10, "1024", false},
+		{"**", -3, 3, "-27", false},
+		{"**", 5, 0, "1", false},
+		{"**", 2, 63, "9223372036854775808", true},
+		{"**", -2, 63, "-9223372036854775808", false},
+		{"&", 12, 10, "8", false},
+		{"|", 12, 10, "14", false},
+		{"^", 12, 10, "6", false},
+		{"<<", 1, 62, "4611686018427387904", false},
+		{"<<", 1, 63, "9223372036854775808", true},
+		{"<<", -1, 63, "-9223372036854775808", false},
+		{"<<", 3, 100, "3802951800684688204490109616128", true},
+		{">>", -8, 1, "-4", false},
+		{">>", -8, 100, "-1", false},
+	}
+
+	for _, tt := range tests {
+		left, right := &Integer{Value: tt.left}, &Integer{Value: tt.right}
+		result, err := IntegerArithmetic(tt.operator, left, right, false)
+		if err != nil || result.Inspect() != tt.expected {
+			t.Errorf("%d %s %d: want=%s, got=%v (%v)", tt.left, tt.operator, tt.right, tt.expected, result, err)
+			continue
+		}
+		if _, isBig := result.(*BigInteger); isBig != tt.overflow {
+			t.Errorf("%d %s %d: wrong representation %T", tt.left, tt.operator, tt.right, result)
+		}
+
+		result, err = IntegerArithmetic(tt.operator, left, right, true)
+		if tt.overflow && err == nil {
+			t.Errorf("%d %s %d: overflow not reported, got=%v", tt.left, tt.operator, tt.right, result)
+		}
+		if !tt.overflow && (err != nil || result.Inspect() != tt.expected) {
+			t.Errorf("%d %s %d: want=%s, got=%v (%v)", tt.left, tt.operator, tt.right, tt.expected, result, err)
+		}
+	}
+
+	errors := []struct {
+		operator string
+		right    int64
+		expected string
+	}{
+		{"/", 0, "division by zero"},
+		{"%", 0, "division by zero"},
+		{"**", -1, "negative exponent: -1"},
+		{"<<", -1, "negative shift count: -1"},
+		{">>", -1, "negative shift count: -1"},
+		{"<<", math.MaxInt64, "shift count too large: 9223372036854775807"},
+		{"**", 1 << 30, "exponent too large: 1073741824"},
+	}
+
+	for _, tt := range errors {
+		_, err := IntegerArithmetic(tt.operator, &Integer{Value: 2}, &Integer{Value: tt.right}, false)
+		if err == nil || err.Message != tt.expected {
+			t.Errorf("2 %s %d: want error %q, got=%v", tt.operator, tt.right, tt.expected, err)
+		}
+	}
+}
+
+func TestNegateInteger(t *testing.T) {
+	result, err := NegateInteger(&Integer{Value: math.MinInt64}, false)
+	if err != nil || result.Inspect() != "9223372036854775808" {
+		t.Errorf("-(%d): want=9223372036854775808, got=%v (%v)", int64(math.MinInt64), result, err)
+	}
+
+	_, err = NegateInteger(&Integer{Value: math.MinInt64}, true)
+	if err == nil || err.Message != "integer overflow: -(-9223372036854775808)" {
+		t.Errorf("-(%d): want overflow error, got=%v", int64(math.MinInt64), err)
+	}
+
+	result, err = NegateInteger(&Integer{Value: math.MinInt64 + 1}, true)
+	if err != nil || result.Inspect() != "9223372036854775807" {
+		t.Errorf("-(%d): want=9223372036854775807, got=%v (%v)", int64(math.MinInt64+1), result, err)
+	}
+}
+
+func TestBigIntegerDemotion(t *testing.T) {
+	huge := &BigInteger{Value: new(big.Int).Lsh(big.NewInt(1), 63)}
+
+	result, err := IntegerArithmetic("-", huge, &Integer{Value: 1}, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	integer, ok := result.(*Integer)
+	if !ok || integer.Value != math.MaxInt64 {
+		t.Errorf("result is not demoted to Integer. got=%T (%+v)", result, result)
+	}
+
+	if CompareIntegers(huge, &Integer{Value: math.MaxInt64}) != 1 {
+		t.Errorf("big integer is not greater than math.MaxInt64")
+	}
+}
+
+func TestBigIntegerHashKey(t *testing.T) {
+	a, _ := new(big.Int).SetString("100000000000000000000", 10)
+	b, _ := new(big.Int).SetString("100000000000000000000", 10)
+	c, _ := new(big.Int).SetString("100000000000000000001", 10)
+
+	if (&BigInteger{Value: a}).HashKey() != (&BigInteger{Value: b}).HashKey() {
+		t.Errorf("big integers with same value have different hash keys")
+	}
+	if (&BigInteger{Value: a}).HashKey() == (&BigInteger{Value: c}).HashKey() {
+		t.Errorf("big integers with different values have same hash keys")
+	}
+}
+
+func TestEqual(t *testing.T) {
+	hash := func(pairs ...Object) *Hash {
+		h := &Hash{}
+		for i := 0; i < len(pairs); i += 2 {
+			h.Set(pairs[i], pairs[i+1])
+		}
+		return h
+	}
+	array := func(elements ...Object) *Array { return &Array{Elements: elements} }
+	one, two := &Integer{Value: 1}, &Integer{Value: 2}
+	str := func(s string) *String { return &String{Value: s} }
+	fn := &Builtin{}
+
+	tests := []struct {
+		a, b     Object
+		expected bool
+	}{
+		{one, &Integer{Value: 1}, true},
+		{one, &Float{Value: 1}, true},
+		{one, two, false},
+		{str("a"), str("a"), true},
+		{str("a"), one, false},
+		{&Null{}, &Null{}, true},
+		{&Null{}, &Boolean{Value: false}, false},
+		{array(one, str("x")), array(&Integer{Value: 1}, str("x")), true},
+		{array(one, two), array(two, one), false},
+		{array(one), array(one, one), false},
+		{array(array(one)), array(array(one)), true},
+		{hash(str("a"), one, two, array()), hash(two, array(), str("a"), one), true},
+		{hash(str("a"), one), hash(str("a"), two), false},
+		{hash(str("a"), one), hash(str("b"), one), false},
+		{fn, fn, true},
+		{fn, &Builtin{}, false},
+	}
+
+	for _, tt := range tests {
+		if got := Equal(tt.a, tt.b); got != tt.expected {
+			t.Errorf("Equal(%s, %s): want=%t, got=%t", tt.a.Inspect(), tt.b.Inspect(), tt.expected, got)
+		}
+	}
+}
+
+func TestEqualCyclic(t *testing.T) {
+	cyclicArray := func(value Object) *Array {
+		a := &Array{Elements: []Object{value, nil}}
+		a.Elements[1] = a
+		return a
+	}
+	cyclicHash := func(value Object) *Hash {
+		h := &Hash{}
+		h.Set(&String{Value: "value"}, value)
+		h.Set(&String{Value: "self"}, h)
+		return h
+	}
+	one, two := &Integer{Value: 1}, &Integer{Value: 2}
+
+	tests := []struct {
+		a, b     Object
+		expected bool
+	}{
+		{cyclicArray(one), cyclicArray(&Integer{Value: 1}), true},
+		{cyclicArray(one), cyclicArray(two), false},
+		{cyclicArray(one), &Array{Elements: []Object{one, cyclicArray(one)}}, true},
+		{cyclicHash(one), cyclicHash(&Integer{Value: 1}), true},
+		{cyclicHash(one), cyclicHash(two), false},
+		{cyclicArray(cyclicHash(one)), cyclicArray(cyclicHash(one)), true},
+	}
+
+	for i, tt := range tests {
+		if got := Equal(tt.a, tt.b); got != tt.expected {
+			t.Errorf("tests[%d]: want=%t, got=%t", i, tt.expected, got)
+		}
+	}
+}
+
+func TestFloatHashKey(t *testing.T) {
+	if (&Float{Value: 2}).HashKey() != (&Integer{Value: 2}).HashKey() {
+		t.Errorf("float with integer value has different hash key than the integer")
+	}
+	huge := new(big.Int).Lsh(big.NewInt(1), 70)
+	if (&Float{Value: math.Pow(2, 70)}).HashKey() != (&BigInteger{Value: huge}).HashKey() {
+		t.Errorf("float with big integer value has different hash key than the big integer")
+	}
+	if (&Float{Value: 2.5}).HashKey() == (&Float{Value: 3.5}).HashKey() {
+		t.Errorf("different floats have same hash keys")
+	}
+}
+
+// collidingKey has the same HashKey as any other collidingKey.
+type collidingKey struct{ name string }
+
+func (k *collidingKey) Type() Type       { return "KEY" }
+func (k *collidingKey) Inspect() string  { return k.name }
+func (k *collidingKey) HashKey() HashKey { return HashKey{Type: "KEY"} }
+
+func TestHashCollisions(t *testing.T) {
+	a, b := &collidingKey{"a"}, &collidingKey{"b"}
+	h := &Hash{}
+	h.Set(a, &Integer{Value: 1})
+	h.Set(b, &Integer{Value: 2})
+	h.Set(a, &Integer{Value: 3})
+
+	if h.Len() != 2 {
+		t.Fatalf("colliding keys overwrite each other. got %d pairs", h.Len())
+	}
+	for key, expected := range map[Object]string{a: "3", b: "2"} {
+		value, ok, err := h.Get(key)
+		if !ok || err != nil || value.Inspect() != expected {
+			t.Errorf("wrong value for %s. want=%s, got=%v", key.Inspect(), expected, value)
+		}
+	}
+}
+
+func TestHashOrder(t *testing.T) {
+	h := &Hash{}
+	for _, key := range []string{"b", "c", "a", "c"} {
+		h.Set(&String{Value: key}, &Integer{Value: int64(h.Len())})
+	}
+
+	if h.Inspect() != "{b: 0, c: 3, a: 2}" {
+		t.Errorf("pairs are not in insertion order. got=%s", h.Inspect())
+	}
+}
+
+func TestHashKeyOf(t *testing.T) {
+	array := func(elements ...Object) *Array { return &Array{Elements: elements} }
+	one, two := &Integer{Value: 1}, &Integer{Value: 2}
+	hashOf := func(obj Object) HashKey {
+		key, err := HashKeyOf(obj)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return key
+	}
+
+	if hashOf(array(one, two)) != hashOf(array(&Integer{Value: 1}, &Float{Value: 2})) {
+		t.Errorf("equal arrays have different hash keys")
+	}
+	if hashOf(array(one, two)) == hashOf(array(two, one)) {
+		t.Errorf("different arrays have same hash keys")
+	}
+	if hashOf(array(array(one), two)) == hashOf(array(one, array(two))) {
+		t.Errorf("differently nested arrays have same hash keys")
+	}
+
+	h1, h2 := &Hash{}, &Hash{}
+	h1.Set(one, two)
+	h1.Set(two, one)
+	h2.Set(two, one)
+	h2.Set(one, two)
+	if hashOf(h1) != hashOf(h2) {
+		t.Errorf("equal hashes have different hash keys")
+	}
+
+	_, err := HashKeyOf(array(one, &Builtin{}))
+	if err == nil || err.Message != "unusable as hash key: BUILTIN" {
+		t.Errorf("wrong error for array with builtin: %v", err)
+	}
+
+	shared := array(one)
+	if hashOf(array(shared, shared)) != hashOf(array(array(one), array(one))) {
+		t.Errorf("array with the same element twice has different hash key")
+	}
+	cyclic := array(one, nil)
+	cyclic.Elements[1] = array(cyclic)
+	_, err = HashKeyOf(cyclic)
+	if err == nil || err.Message != "unusable as hash key: ARRAY" {
+		t.Errorf("wrong error for cyclic array: %v", err)
+	}
+	cyclicHash := &Hash{}
+	cyclicHash.Set(one, cyclicHash)
+	_, err = HashKeyOf(cyclicHash)
+	if err == nil || err.Message != "unusable as hash key: HASH" {
+		t.Errorf("wrong error for cyclic hash: %v", err)
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	inner := &Array{Elements: []Object{&Integer{Value: 1}}}
+	obj, err := Freeze(&Array{Elements: []Object{inner}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	frozen := obj.(*Array)
+
+	if !frozen.Frozen || !frozen.Elements[0].(*Array).Frozen {
+		t.Fatalf("array is not frozen deeply")
+	}
+	if obj, _ := Freeze(frozen); obj != frozen {
+		t.Errorf("frozen array is copied again")
+	}
+	inner.Elements[0] = &Integer{Value: 2}
+	if frozen.Inspect() != "[[1]]" {
+		t.Errorf("frozen array shares elements with the original. got=%s", frozen.Inspect())
+	}
+	err = SetIndex(frozen, &Integer{Value: 0}, &Integer{Value: 2})
+	if err == nil || err.Message != "cannot modify frozen ARRAY" {
+		t.Errorf("wrong error for frozen array: %v", err)
+	}
+
+	h := &Hash{}
+	h.Set(inner, &Integer{Value: 1})
+	if key := h.Pairs()[0].Key.(*Array); key == inner || !key.Frozen {
+		t.Errorf("hash key is not stored frozen")
+	}
+
+	shared := &Array{Elements: []Object{inner, inner}}
+	if _, err := Freeze(shared); err != nil {
+		t.Errorf("unexpected error for array with the same element twice: %v", err)
+	}
+	cyclic := &Array{Elements: []Object{inner, nil}}
+	cyclic.Elements[1] = &Array{Elements: []Object{cyclic}}
+	_, err = Freeze(cyclic)
+	if err == nil || err.Message != "cannot freeze cyclic value: ARRAY" {
+		t.Errorf("wrong error for cyclic array: %v", err)
+	}
+	cyclicHash := &Hash{}
+	cyclicHash.Set(&Integer{Value: 1}, cyclicHash)
+	_, err = Freeze(cyclicHash)
+	if err == nil || err.Message != "cannot freeze cyclic value: HASH" {
+		t.Errorf("wrong error for cyclic hash: %v", err)
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{1.5, "1.5"},
+		{-2, "-2.0"},
+		{0, "0.0"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+		{math.NaN(), "NaN"},
+	}
+
+	for _, tt := range tests {
+		if got := (&Float{Value: tt.value}).Inspect(); got != tt.expected {
+			t.Errorf("wrong Inspect for %v. want=%q, got=%q", tt.value, tt.expected, got)
+		}
+	}
+}
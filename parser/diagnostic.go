@@ -0,0 +1,71 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/idexter/monkey/token"
+)
+
+// Severity describes how serious the reported problem is.
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+}
+
+// Code identifies the kind of problem, so tools don't have to match messages.
+type Code string
+
+const (
+	CodeUnexpectedToken     Code = "unexpected-token"
+	CodeExpectedExpression  Code = "expected-expression"
+	CodeInvalidInteger      Code = "invalid-integer"
+	CodeInvalidFloat        Code = "invalid-float"
+	CodeInvalidString       Code = "invalid-string"
+	CodeOutsideLoop         Code = "outside-loop"
+	CodeControlInExpression Code = "control-in-expression"
+	CodeInvalidAssignment   Code = "invalid-assignment"
+	CodeRedeclared          Code = "redeclared"
+	CodeDeprecated          Code = "deprecated"
+)
+
+// Diagnostic describes a problem found in the source code.
+type Diagnostic struct {
+	Severity Severity
+	Code     Code
+	Message  string
+	Span     token.Span   // source code the diagnostic is about
+	Expected []token.Type // token types which were expected, if known
+	Actual   token.Type   // type of the offending token
+	Hints    []string     // suggestions on how to fix the problem
+}
+
+// String renders diagnostic in the form "file:line:col: message".
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s", d.Span.Start, d.Message)
+}
+
+var closingHints = map[token.Type]string{
+	token.RPAREN:   "check for a missing closing parenthesis ')'",
+	token.RBRACKET: "check for a missing closing bracket ']'",
+	token.RBRACE:   "check for a missing closing brace '}'",
+}
+
+func hintsFor(expected token.Type) []string {
+	if hint, ok := closingHints[expected]; ok {
+		return []string{hint}
+	}
+	return nil
+}
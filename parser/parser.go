@@ -1,8 +1,11 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
 	"strconv"
+	"strings"
 
 	"github.com/idexter/monkey/ast"
 	"github.com/idexter/monkey/lexer"
@@ -12,26 +15,46 @@ import (
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN      // = or +=
+	OR          // ||
+	AND         // &&
 	EQUALS      // ==
 	LESSGREATER // > or <
-	SUM         // +
-	PRODUCT     // *
+	SUM         // + - | ^
+	PRODUCT     // * / % & << >>
 	PREFIX      // -X or !X
+	POWER       // **
 	CALL        // myFunction(X)
 	INDEX       // array[index]
 )
 
 var precedences = map[token.Type]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.ASTERISK: PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.ASSIGN:          ASSIGN,
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+	token.OR:              OR,
+	token.AND:             AND,
+	token.EQ:              EQUALS,
+	token.NOT_EQ:          EQUALS,
+	token.LT:              LESSGREATER,
+	token.GT:              LESSGREATER,
+	token.LT_EQ:           LESSGREATER,
+	token.GT_EQ:           LESSGREATER,
+	token.PLUS:            SUM,
+	token.MINUS:           SUM,
+	token.BIT_OR:          SUM,
+	token.BIT_XOR:         SUM,
+	token.SLASH:           PRODUCT,
+	token.ASTERISK:        PRODUCT,
+	token.PERCENT:         PRODUCT,
+	token.BIT_AND:         PRODUCT,
+	token.SHIFT_LEFT:      PRODUCT,
+	token.SHIFT_RIGHT:     PRODUCT,
+	token.POWER:           POWER,
+	token.LPAREN:          CALL,
+	token.LBRACKET:        INDEX,
 }
 
 type (
@@ -39,27 +62,84 @@ type (
 	infixParseFn  func(expression ast.Expression) ast.Expression
 )
 
+// statementStarts contains tokens which may only start a statement,
+// so parsing can resume at them after a syntax error.
+var statementStarts = map[token.Type]bool{
+	token.LET:      true,
+	token.CONST:    true,
+	token.RETURN:   true,
+	token.WHILE:    true,
+	token.FOR:      true,
+	token.BREAK:    true,
+	token.CONTINUE: true,
+}
+
+// terminators contains tokens which end an enclosing construct.
+var terminators = map[token.Type]bool{
+	token.SEMICOLON: true,
+	token.RPAREN:    true,
+	token.RBRACKET:  true,
+	token.RBRACE:    true,
+	token.EOF:       true,
+}
+
+// Config holds the options of a parser.
+type Config struct {
+	// ForbidRedeclaration makes declaring a variable which is already
+	// declared in the same scope an error. Constants can never be redeclared.
+	ForbidRedeclaration bool
+}
+
 type Parser struct {
-	l *lexer.Lexer
+	l      *lexer.Lexer
+	config Config
 
+	prevToken token.Token
 	curToken  token.Token
 	peekToken token.Token
 
+	unread []token.Token // tokens put back by backup, read before the lexer ones
+	depth  int           // nesting of braces up to and including curToken
+	blocks int           // nesting of block statements being parsed
+	loops  int           // nesting of loops in the function being parsed
+
+	// controls holds break and continue statements of the innermost loop
+	// which have to be checked to be used as statements, and not as the
+	// value of an if expression used in an expression.
+	controls []token.Token
+
+	// declared maps names declared in the function being parsed, or at the
+	// top level, to whether they are constants.
+	declared map[string]bool
+
+	start token.Position // position of the statement being parsed
+
+	// panicking is set after a syntax error is reported, further errors are
+	// not reported until parser synchronizes at the start of the next statement.
+	panicking bool
+
 	prefixParseFns map[token.Type]prefixParseFn
 	infixParseFns  map[token.Type]infixParseFn
 
-	errors []string
+	diagnostics []Diagnostic
 }
 
 func New(l *lexer.Lexer) *Parser {
+	return NewWithConfig(l, Config{})
+}
+
+func NewWithConfig(l *lexer.Lexer, config Config) *Parser {
 	p := &Parser{
-		l:      l,
-		errors: []string{},
+		l:           l,
+		config:      config,
+		declared:    make(map[string]bool),
+		diagnostics: []Diagnostic{},
 	}
 
 	p.prefixParseFns = make(map[token.Type]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
@@ -68,6 +148,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.IF, p.parseIfExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.STRING_HEAD, p.parseInterpolatedString)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
 	p.registerPrefix(token.SHARP, p.parseCommentLiteral)
@@ -81,8 +162,24 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.POWER, p.parseInfixExpression)
+	p.registerInfix(token.BIT_AND, p.parseInfixExpression)
+	p.registerInfix(token.BIT_OR, p.parseInfixExpression)
+	p.registerInfix(token.BIT_XOR, p.parseInfixExpression)
+	p.registerInfix(token.SHIFT_LEFT, p.parseInfixExpression)
+	p.registerInfix(token.SHIFT_RIGHT, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseAssignExpression)
 
 	// Read two tokens, so curToken and peekToken are both set
 	p.nextToken()
@@ -91,8 +188,20 @@ func New(l *lexer.Lexer) *Parser {
 	return p
 }
 
+// Errors returns rendered error diagnostics.
 func (p *Parser) Errors() []string {
-	return p.errors
+	errors := []string{}
+	for _, d := range p.diagnostics {
+		if d.Severity == SeverityError {
+			errors = append(errors, d.String())
+		}
+	}
+	return errors
+}
+
+// Diagnostics returns all problems found while parsing.
+func (p *Parser) Diagnostics() []Diagnostic {
+	return p.diagnostics
 }
 
 func (p *Parser) parseIdentifier() ast.Expression {
@@ -104,24 +213,220 @@ func (p *Parser) parseIdentifier() ast.Expression {
 
 func (p *Parser) parseIntegerLiteral() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: p.curToken}
+	if message, hint := integerLiteralError(p.curToken.Literal); message != "" {
+		d := Diagnostic{
+			Severity: SeverityError,
+			Code:     CodeInvalidInteger,
+			Message:  message,
+			Span:     p.curToken.Span(),
+			Actual:   p.curToken.Type,
+		}
+		if hint != "" {
+			d.Hints = []string{hint}
+		}
+		p.report(d)
+		return p.badExpression(lit.Token)
+	}
+	if isLegacyOctal(p.curToken.Literal) {
+		digits := strings.TrimLeft(p.curToken.Literal, "0_")
+		if digits == "" {
+			digits = "0"
+		}
+		p.report(Diagnostic{
+			Severity: SeverityWarning,
+			Code:     CodeDeprecated,
+			Message:  fmt.Sprintf("octal literal %s with a leading 0 is deprecated", p.curToken.Literal),
+			Span:     p.curToken.Span(),
+			Actual:   p.curToken.Type,
+			Hints:    []string{fmt.Sprintf("write 0o%s for the same value, or %s for a decimal literal", digits, digits)},
+		})
+	}
+
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
-		return nil
+		// The literal is well-formed, so it only doesn't fit in int64.
+		lit.Big, _ = new(big.Int).SetString(p.curToken.Literal, 0)
+		return lit
+	}
+	lit.Value = value
+	return lit
+}
+
+// integerLiteralError describes what is wrong with the integer literal, or
+// returns an empty message if it is well-formed. The hint, if any, suggests
+// how to fix it.
+func integerLiteralError(literal string) (message, hint string) {
+	base, name, digits := 10, "decimal", literal
+	if len(literal) > 1 && literal[0] == '0' {
+		base, name, digits = 8, "octal", literal[2:]
+		switch literal[1] {
+		case 'x', 'X':
+			base, name = 16, "hexadecimal"
+		case 'o', 'O':
+		case 'b', 'B':
+			base, name = 2, "binary"
+		default:
+			if isLegacyOctal(literal) {
+				digits = literal[1:]
+				break
+			}
+			return fmt.Sprintf("decimal literal %s can't start with 0", literal),
+				fmt.Sprintf("write %s", strings.TrimLeft(literal, "0_"))
+		}
+	}
+
+	if strings.Trim(digits, "_") == "" {
+		return fmt.Sprintf("%s literal %s has no digits", name, literal), ""
+	}
+	for i, ch := range digits {
+		if ch == '_' {
+			// Underscores separate digits, or the prefix from the first digit.
+			if i+1 == len(digits) || digits[i+1] == '_' || (i == 0 && base == 10) {
+				return fmt.Sprintf("'_' must separate successive digits in %s", literal), ""
+			}
+		} else if digitValue(ch) >= base {
+			return fmt.Sprintf("invalid digit %q in %s literal %s", ch, name, literal), ""
+		}
+	}
+	return "", ""
+}
+
+// isLegacyOctal reports whether literal is an octal literal written with a
+// leading 0 and no 0o prefix, like 017, which is still accepted but deprecated.
+func isLegacyOctal(literal string) bool {
+	return len(literal) > 1 && literal[0] == '0' && strings.Trim(literal, "01234567_") == ""
+}
+
+// digitValue returns the value of the hexadecimal digit ch, or 16 if ch isn't one.
+func digitValue(ch rune) int {
+	switch {
+	case '0' <= ch && ch <= '9':
+		return int(ch - '0')
+	case 'a' <= ch && ch <= 'f':
+		return int(ch - 'a' + 10)
+	case 'A' <= ch && ch <= 'F':
+		return int(ch - 'A' + 10)
+	}
+	return 16
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		message := fmt.Sprintf("could not parse %q as float", p.curToken.Literal)
+		if errors.Is(err, strconv.ErrSyntax) && strings.Contains(p.curToken.Literal, "_") {
+			message = fmt.Sprintf("'_' must separate successive digits in %s", p.curToken.Literal)
+		}
+		p.report(Diagnostic{
+			Severity: SeverityError,
+			Code:     CodeInvalidFloat,
+			Message:  message,
+			Span:     p.curToken.Span(),
+			Actual:   p.curToken.Type,
+		})
+		return p.badExpression(lit.Token)
 	}
 	lit.Value = value
 	return lit
 }
 
 func (p *Parser) peekError(t token.Type) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead", t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.unexpectedTokenError(p.peekToken, t)
+}
+
+func (p *Parser) unexpectedTokenError(tok token.Token, expected token.Type) {
+	p.report(Diagnostic{
+		Severity: SeverityError,
+		Code:     CodeUnexpectedToken,
+		Message:  fmt.Sprintf("expected next token to be %s, got %s instead", expected, tok.Type),
+		Span:     tok.Span(),
+		Expected: []token.Type{expected},
+		Actual:   tok.Type,
+		Hints:    hintsFor(expected),
+	})
+}
+
+// report records diagnostic unless parser is recovering from a previous
+// syntax error, which would make it most likely a consequence of that error.
+func (p *Parser) report(d Diagnostic) {
+	if p.panicking {
+		return
+	}
+	p.diagnostics = append(p.diagnostics, d)
+	if d.Severity == SeverityError {
+		p.panicking = true
+	}
 }
 
 func (p *Parser) nextToken() {
+	p.prevToken = p.curToken
 	p.curToken = p.peekToken
-	p.peekToken = p.l.NextToken()
+	if n := len(p.unread); n > 0 {
+		p.peekToken = p.unread[n-1]
+		p.unread = p.unread[:n-1]
+	} else {
+		p.peekToken = p.l.NextToken()
+	}
+
+	switch p.curToken.Type {
+	case token.LBRACE:
+		p.depth++
+	case token.RBRACE:
+		p.depth--
+	}
+}
+
+// backup puts the current token back, so it is read again by the next
+// call to nextToken. Only a single token can be put back.
+func (p *Parser) backup() {
+	switch p.curToken.Type {
+	case token.LBRACE:
+		p.depth--
+	case token.RBRACE:
+		p.depth++
+	}
+
+	p.unread = append(p.unread, p.peekToken)
+	p.peekToken = p.curToken
+	p.curToken = p.prevToken
+}
+
+// synchronize skips tokens after a syntax error, so parsing can resume at the
+// start of the next statement. The broken statement started at the given
+// nesting of braces.
+func (p *Parser) synchronize(depth int) {
+	if statementStarts[p.curToken.Type] && p.curToken.Pos != p.start {
+		// The error is a missing end of statement, so the current token
+		// starts the next one.
+		p.backup()
+		return
+	}
+
+	for !p.curTokenIs(token.EOF) {
+		if p.curTokenIs(token.RBRACE) && p.depth < depth {
+			// The brace closes the enclosing block, which has to see it.
+			if p.blocks > 0 {
+				p.backup()
+			}
+			return
+		}
+
+		if p.depth == depth {
+			if p.curTokenIs(token.SEMICOLON) ||
+				p.peekTokenIs(token.RBRACE) ||
+				p.peekTokenIs(token.EOF) ||
+				statementStarts[p.peekToken.Type] {
+				return
+			}
+		}
+
+		p.nextToken()
+	}
+}
+
+func (p *Parser) badExpression(from token.Token) ast.Expression {
+	return &ast.BadExpression{From: from, To: p.curToken}
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
@@ -140,14 +445,41 @@ func (p *Parser) ParseProgram() *ast.Program {
 }
 
 func (p *Parser) parseStatement() ast.Statement {
+	from := p.curToken
+	depth := p.depth
+	if from.Type == token.LBRACE {
+		depth--
+	}
+	start, panicking := p.start, p.panicking
+	p.start, p.panicking = from.Pos, false
+	controls := len(p.controls)
+
+	var stmt ast.Statement
 	switch p.curToken.Type {
-	case token.LET:
-		return p.parseLetStatement()
+	case token.LET, token.CONST:
+		stmt = p.parseLetStatement()
 	case token.RETURN:
-		return p.parseReturnStatement()
+		stmt = p.parseReturnStatement()
+	case token.WHILE:
+		stmt = p.parseWhileStatement()
+	case token.FOR:
+		stmt = p.parseForStatement()
+	case token.BREAK, token.CONTINUE:
+		stmt = p.parseLoopControlStatement()
 	default:
-		return p.parseExpressionStatement()
+		stmt = p.parseExpressionStatement()
+	}
+
+	if p.panicking {
+		p.synchronize(depth)
+		stmt = &ast.BadStatement{From: from, To: p.curToken}
+		p.controls = p.controls[:controls]
+	} else if !isControlStatement(stmt) {
+		p.checkControls(controls)
 	}
+	p.start, p.panicking = start, panicking
+
+	return stmt
 }
 
 func (p *Parser) parseLetStatement() *ast.LetStatement {
@@ -158,6 +490,7 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	}
 
 	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	p.declare(stmt.Name, stmt.IsConst())
 
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
@@ -166,20 +499,181 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	p.nextToken()
 	stmt.Value = p.parseExpression(LOWEST)
 
-	for !p.curTokenIs(token.SEMICOLON) {
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		fl.Name = stmt.Name.Value
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 
 	return stmt
 }
 
+// declare records the name declared in the current scope and reports it if
+// it is declared already and may not be redeclared.
+func (p *Parser) declare(name *ast.Identifier, constant bool) {
+	wasConst, ok := p.declared[name.Value]
+	p.declared[name.Value] = constant || wasConst
+	if !ok || (!wasConst && !p.config.ForbidRedeclaration) || p.panicking {
+		return
+	}
+
+	message := fmt.Sprintf("%s is already declared in this scope", name.Value)
+	if wasConst {
+		message = fmt.Sprintf("cannot redeclare constant %s", name.Value)
+	}
+	p.report(Diagnostic{
+		Severity: SeverityError,
+		Code:     CodeRedeclared,
+		Message:  message,
+		Span:     name.Token.Span(),
+		Actual:   name.Token.Type,
+	})
+	// There is nothing to skip, the statement itself is well-formed.
+	p.panicking = false
+}
+
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	stmt := &ast.ReturnStatement{Token: p.curToken}
 	p.nextToken()
 
 	stmt.ReturnValue = p.parseExpression(LOWEST)
 
-	for !p.curTokenIs(token.SEMICOLON) {
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseWhileStatement() *ast.WhileStatement {
+	stmt := &ast.WhileStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseForStatement() *ast.ForStatement {
+	stmt := &ast.ForStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+
+	stmt.Variable = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loops++
+	controls := p.controls
+	p.controls = nil
+	defer func() { p.loops, p.controls = p.loops-1, controls }()
+	return p.parseBlockStatement()
+}
+
+// isControlStatement reports whether break and continue statements in stmt
+// are used as statements: stmt is one of them, or an if expression they are
+// used as statements in, whose value is not used.
+func isControlStatement(stmt ast.Statement) bool {
+	switch stmt := stmt.(type) {
+	case *ast.BreakStatement, *ast.ContinueStatement:
+		return true
+	case *ast.ExpressionStatement:
+		_, ok := stmt.Expression.(*ast.IfExpression)
+		return ok
+	}
+	return false
+}
+
+// checkControls reports the break and continue statements parsed after the
+// first n ones, which are used as values, and forgets them.
+func (p *Parser) checkControls(n int) {
+	for _, tok := range p.controls[n:] {
+		p.report(Diagnostic{
+			Severity: SeverityError,
+			Code:     CodeControlInExpression,
+			Message:  fmt.Sprintf("%s can't be used as a value", tok.Literal),
+			Span:     tok.Span(),
+			Actual:   tok.Type,
+			Hints:    []string{fmt.Sprintf("use %s as a statement of an if expression which is not part of another expression", tok.Literal)},
+		})
+		p.panicking = false
+	}
+	p.controls = p.controls[:n]
+}
+
+// parseLoopControlStatement parses break and continue statements.
+func (p *Parser) parseLoopControlStatement() ast.Statement {
+	var stmt ast.Statement
+	if p.curTokenIs(token.BREAK) {
+		stmt = &ast.BreakStatement{Token: p.curToken}
+	} else {
+		stmt = &ast.ContinueStatement{Token: p.curToken}
+	}
+
+	if p.loops == 0 {
+		p.report(Diagnostic{
+			Severity: SeverityError,
+			Code:     CodeOutsideLoop,
+			Message:  fmt.Sprintf("%s outside of loop", p.curToken.Literal),
+			Span:     p.curToken.Span(),
+			Actual:   p.curToken.Type,
+		})
+		// There is nothing to skip, the statement itself is well-formed.
+		p.panicking = false
+	} else {
+		p.controls = append(p.controls, p.curToken)
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 
@@ -201,7 +695,12 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
 		p.noPrefixParseFnError(p.curToken.Type)
-		return nil
+		bad := p.badExpression(p.curToken)
+		if terminators[p.curToken.Type] && p.curToken.Pos != p.start {
+			// Leave the terminator to the enclosing construct.
+			p.backup()
+		}
+		return bad
 	}
 	leftExp := prefix()
 
@@ -244,8 +743,48 @@ func (p *Parser) registerInfix(tokenType token.Type, fn infixParseFn) {
 }
 
 func (p *Parser) noPrefixParseFnError(t token.Type) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	d := Diagnostic{
+		Severity: SeverityError,
+		Code:     CodeExpectedExpression,
+		Message:  fmt.Sprintf("no prefix parse function for %s found", t),
+		Span:     p.curToken.Span(),
+		Actual:   t,
+	}
+	if t == token.ILLEGAL {
+		if p.invalidStringError(p.curToken) {
+			return
+		}
+		d.Hints = []string{fmt.Sprintf("unexpected character %q", p.curToken.Literal)}
+	}
+	if t == token.STRING_MIDDLE || t == token.STRING_TAIL {
+		d.Hints = []string{"put an expression between ${ and }"}
+	}
+	p.report(d)
+}
+
+// invalidStringError reports the problem with tok if it is a malformed
+// string, which the lexer reads as ILLEGAL token.
+func (p *Parser) invalidStringError(tok token.Token) bool {
+	if tok.Type != token.ILLEGAL || !strings.ContainsAny(tok.Literal[:1], "\"`}") {
+		return false
+	}
+	_, err := lexer.Unquote(tok.Literal)
+	d := Diagnostic{
+		Severity: SeverityError,
+		Code:     CodeInvalidString,
+		Message:  err.Error(),
+		Span:     tok.Span(),
+		Actual:   tok.Type,
+	}
+	if err == lexer.ErrUnterminated {
+		if tok.Literal[0] == '`' {
+			d.Hints = []string{"add the closing `"}
+		} else {
+			d.Hints = []string{`add the closing "`}
+		}
+	}
+	p.report(d)
+	return true
 }
 
 func (p *Parser) parsePrefixExpression() ast.Expression {
@@ -283,12 +822,43 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	}
 
 	precedence := p.curPrecedence()
+	if expression.Operator == "**" {
+		// Exponentiation is right-associative: 2 ** 3 ** 2 is 2 ** 9.
+		precedence--
+	}
 	p.nextToken()
 	expression.Right = p.parseExpression(precedence)
 
 	return expression
 }
 
+func (p *Parser) parseAssignExpression(target ast.Expression) ast.Expression {
+	expression := &ast.AssignExpression{
+		Token:    p.curToken,
+		Target:   target,
+		Operator: p.curToken.Literal,
+	}
+
+	switch target.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	default:
+		p.report(Diagnostic{
+			Severity: SeverityError,
+			Code:     CodeInvalidAssignment,
+			Message:  fmt.Sprintf("cannot assign to %s", target.String()),
+			Span:     token.Span{Start: target.Pos(), End: target.End()},
+			Actual:   p.curToken.Type,
+		})
+		return p.badExpression(expression.Token)
+	}
+
+	// Assignment is right-associative: a = b = c assigns c to both.
+	p.nextToken()
+	expression.Value = p.parseExpression(ASSIGN - 1)
+
+	return expression
+}
+
 func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{
 		Token: p.curToken,
@@ -297,10 +867,11 @@ func (p *Parser) parseBoolean() ast.Expression {
 }
 
 func (p *Parser) parseGroupedExpression() ast.Expression {
+	from := p.curToken
 	p.nextToken()
 	exp := p.parseExpression(LOWEST)
 	if !p.expectPeek(token.RPAREN) {
-		return nil
+		return p.badExpression(from)
 	}
 	return exp
 }
@@ -309,18 +880,20 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	expression := &ast.IfExpression{Token: p.curToken}
 
 	if !p.expectPeek(token.LPAREN) {
-		return nil
+		return p.badExpression(expression.Token)
 	}
 
 	p.nextToken()
+	controls := len(p.controls)
 	expression.Condition = p.parseExpression(LOWEST)
+	p.checkControls(controls)
 
 	if !p.expectPeek(token.RPAREN) {
-		return nil
+		return p.badExpression(expression.Token)
 	}
 
 	if !p.expectPeek(token.LBRACE) {
-		return nil
+		return p.badExpression(expression.Token)
 	}
 
 	expression.Consequence = p.parseBlockStatement()
@@ -329,7 +902,7 @@ func (p *Parser) parseIfExpression() ast.Expression {
 		p.nextToken()
 
 		if !p.expectPeek(token.LBRACE) {
-			return nil
+			return p.badExpression(expression.Token)
 		}
 
 		expression.Alternative = p.parseBlockStatement()
@@ -344,6 +917,7 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 
 	p.nextToken()
 
+	p.blocks++
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
 		stmt := p.parseStatement()
 		if stmt != nil {
@@ -351,6 +925,12 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		}
 		p.nextToken()
 	}
+	p.blocks--
+
+	if p.curTokenIs(token.EOF) {
+		p.unexpectedTokenError(p.curToken, token.RBRACE)
+	}
+	block.Rbrace = p.curToken
 	return block
 }
 
@@ -358,16 +938,28 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	lit := &ast.FunctionLiteral{Token: p.curToken}
 
 	if !p.expectPeek(token.LPAREN) {
-		return nil
+		return p.badExpression(lit.Token)
 	}
 
+	// The function body is a new scope, which starts with the parameters.
+	declared := p.declared
+	p.declared = make(map[string]bool)
+	defer func() { p.declared = declared }()
+
 	lit.Parameters = p.parseFunctionParameters()
+	for _, param := range lit.Parameters {
+		p.declare(param, false)
+	}
 
 	if !p.expectPeek(token.LBRACE) {
-		return nil
+		return p.badExpression(lit.Token)
 	}
 
+	// Loops around the function can't be broken out of from its body.
+	loops := p.loops
+	p.loops = 0
 	lit.Body = p.parseBlockStatement()
+	p.loops = loops
 
 	return lit
 }
@@ -380,14 +972,18 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 		return identifiers
 	}
 
-	p.nextToken()
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
 
 	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 	identifiers = append(identifiers, ident)
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
-		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
 		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 		identifiers = append(identifiers, ident)
 	}
@@ -402,6 +998,7 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.curToken, Function: function}
 	exp.Arguments = p.parseExpressionList(token.RPAREN)
+	exp.Rparen = p.curToken
 	return exp
 }
 
@@ -433,6 +1030,28 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+// parseInterpolatedString parses a string with embedded expressions, which
+// the lexer splits into a STRING_HEAD, the tokens of each expression with
+// STRING_MIDDLE tokens between them and a STRING_TAIL.
+func (p *Parser) parseInterpolatedString() ast.Expression {
+	str := &ast.InterpolatedString{Token: p.curToken}
+	str.Parts = append(str.Parts, &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal})
+	for !p.curTokenIs(token.STRING_TAIL) {
+		p.nextToken()
+		str.Parts = append(str.Parts, p.parseExpression(LOWEST))
+		if !p.peekTokenIs(token.STRING_MIDDLE) && !p.peekTokenIs(token.STRING_TAIL) {
+			if !p.invalidStringError(p.peekToken) {
+				p.peekError(token.RBRACE)
+			}
+			return p.badExpression(str.Token)
+		}
+		p.nextToken()
+		str.Parts = append(str.Parts, &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal})
+	}
+	str.Rquote = p.curToken
+	return str
+}
+
 func (p *Parser) parseCommentLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
@@ -440,6 +1059,7 @@ func (p *Parser) parseCommentLiteral() ast.Expression {
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.curToken}
 	array.Elements = p.parseExpressionList(token.RBRACKET)
+	array.Rbracket = p.curToken
 	return array
 }
 
@@ -465,32 +1085,53 @@ func (p *Parser) parseExpressionList(end token.Type) []ast.Expression {
 
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
-	p.nextToken()
-	exp.Index = p.parseExpression(LOWEST)
+	if !p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		exp.Index = p.parseExpression(LOWEST)
+	}
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		return p.parseSliceExpression(exp.Token, left, exp.Index)
+	}
 	if !p.expectPeek(token.RBRACKET) {
-		return nil
+		return p.badExpression(exp.Token)
+	}
+	exp.Rbracket = p.curToken
+	return exp
+}
+
+// parseSliceExpression parses the rest of left[low:high] after the colon.
+func (p *Parser) parseSliceExpression(tok token.Token, left, low ast.Expression) ast.Expression {
+	exp := &ast.SliceExpression{Token: tok, Left: left, Low: low}
+	if !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		exp.High = p.parseExpression(LOWEST)
+	}
+	if !p.expectPeek(token.RBRACKET) {
+		return p.badExpression(exp.Token)
 	}
+	exp.Rbracket = p.curToken
 	return exp
 }
 
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{Token: p.curToken}
-	hash.Pairs = make(map[ast.Expression]ast.Expression)
 	for !p.peekTokenIs(token.RBRACE) {
 		p.nextToken()
 		key := p.parseExpression(LOWEST)
 		if !p.expectPeek(token.COLON) {
-			return nil
+			return p.badExpression(hash.Token)
 		}
 		p.nextToken()
 		value := p.parseExpression(LOWEST)
-		hash.Pairs[key] = value
+		hash.Pairs = append(hash.Pairs, ast.HashPair{Key: key, Value: value})
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
-			return nil
+			return p.badExpression(hash.Token)
 		}
 	}
 	if !p.expectPeek(token.RBRACE) {
-		return nil
+		return p.badExpression(hash.Token)
 	}
+	hash.Rbrace = p.curToken
 	return hash
 }
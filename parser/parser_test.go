@@ -6,6 +6,7 @@ import (
 
 	"github.com/idexter/monkey/ast"
 	"github.com/idexter/monkey/lexer"
+	"github.com/idexter/monkey/token"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -123,6 +124,123 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	assert.Equal(t, "5", ident.TokenLiteral(), "ident.TokenLiteral not %s", "5")
 }
 
+func TestIntegerLiteralBases(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"0xFF", 255},
+		{"0Xff", 255},
+		{"0o17", 15},
+		{"0b1010", 10},
+		{"017", 15},
+		{"0_17", 15},
+		{"00", 0},
+		{"1_000_000", 1000000},
+		{"0x_dead_BEEF", 0xdeadbeef},
+		{"0", 0},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		lit, ok := stmt.Expression.(*ast.IntegerLiteral)
+		require.True(t, ok, "exp not *ast.IntegerLiteral. got=%T", stmt.Expression)
+		assert.Equal(t, tt.expected, lit.Value, tt.input)
+	}
+}
+
+func TestIntegerLiteralErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+		expectedHints   []string
+	}{
+		{"09", "decimal literal 09 can't start with 0", []string{"write 9"}},
+		{"0_19", "decimal literal 0_19 can't start with 0", []string{"write 19"}},
+		{"01__7", "'_' must separate successive digits in 01__7", nil},
+		{"0x", "hexadecimal literal 0x has no digits", nil},
+		{"0b_", "binary literal 0b_ has no digits", nil},
+		{"0b102", "invalid digit '2' in binary literal 0b102", nil},
+		{"0o8", "invalid digit '8' in octal literal 0o8", nil},
+		{"0xFG", "invalid digit 'G' in hexadecimal literal 0xFG", nil},
+		{"1__000", "'_' must separate successive digits in 1__000", nil},
+		{"1_", "'_' must separate successive digits in 1_", nil},
+		{"0xFF_", "'_' must separate successive digits in 0xFF_", nil},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		require.NotEmpty(t, p.Diagnostics(), "expected diagnostics for %q", tt.input)
+		d := p.Diagnostics()[0]
+		assert.Equal(t, CodeInvalidInteger, d.Code)
+		assert.Equal(t, tt.expectedMessage, d.Message)
+		assert.Equal(t, tt.expectedHints, d.Hints, tt.input)
+		assert.Equal(t, len(tt.input)+1, d.Span.End.Column, "wrong end of %q", tt.input)
+	}
+}
+
+func TestLegacyOctalLiteral(t *testing.T) {
+	p := New(lexer.New("let x = 017;"))
+	p.ParseProgram()
+	assert.Empty(t, p.Errors())
+
+	require.Len(t, p.Diagnostics(), 1)
+	d := p.Diagnostics()[0]
+	assert.Equal(t, SeverityWarning, d.Severity)
+	assert.Equal(t, CodeDeprecated, d.Code)
+	assert.Equal(t, "1:9: octal literal 017 with a leading 0 is deprecated", d.String())
+	assert.Equal(t, []string{"write 0o17 for the same value, or 17 for a decimal literal"}, d.Hints)
+}
+
+func TestBigIntegerLiteralExpression(t *testing.T) {
+	l := lexer.New("99999999999999999999;")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	require.Len(t, program.Statements, 1, "program has not enough statements.")
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	require.True(t, ok, "program.Statements[0] is not ast.ExpressionStatement.")
+
+	lit, ok := stmt.Expression.(*ast.IntegerLiteral)
+	require.True(t, ok, "exp not *ast.IntegerLiteral. got=%T", stmt.Expression)
+	require.NotNil(t, lit.Big, "literal is not parsed as big integer")
+	assert.Equal(t, "99999999999999999999", lit.Big.String())
+}
+
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14;", 3.14},
+		{"1e3;", 1000},
+		{"2.5e-1;", 0.25},
+		{"1_000.5;", 1000.5},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		require.Len(t, program.Statements, 1, "program has not enough statements.")
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		require.True(t, ok, "program.Statements[0] is not ast.ExpressionStatement.")
+
+		lit, ok := stmt.Expression.(*ast.FloatLiteral)
+		require.True(t, ok, "exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+		assert.Equal(t, tt.expected, lit.Value)
+	}
+}
+
 func TestParsingPrefixExpressions(t *testing.T) {
 	prefixTests := []struct {
 		input        string
@@ -211,6 +329,42 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"-a * b",
 			"((-a) * b)",
 		},
+		{
+			"a || b && c == d",
+			"(a || (b && (c == d)))",
+		},
+		{
+			"a && b || !c",
+			"((a && b) || (!c))",
+		},
+		{
+			"x = a || b",
+			"(x = (a || b))",
+		},
+		{
+			"a <= b == c >= d",
+			"((a <= b) == (c >= d))",
+		},
+		{
+			"a + b % c * d",
+			"(a + ((b % c) * d))",
+		},
+		{
+			"-2 ** 3 ** 2",
+			"(-(2 ** (3 ** 2)))",
+		},
+		{
+			"a * b ** -c",
+			"(a * (b ** (-c)))",
+		},
+		{
+			"a | b & c << 1 ^ d",
+			"((a | ((b & c) << 1)) ^ d)",
+		},
+		{
+			"a >> 1 < b",
+			"((a >> 1) < b)",
+		},
 		{
 			"!-a",
 			"(!(-a))",
@@ -307,6 +461,14 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"a * [1, 2, 3, 4][b * c] * d",
 			"((a * ([1, 2, 3, 4][(b * c)])) * d)",
 		},
+		{
+			"a[1 + 1:b * 2] + s[:n][n:]",
+			"((a[(1 + 1):(b * 2)]) + ((s[:n])[n:]))",
+		},
+		{
+			"a[:]",
+			"(a[:])",
+		},
 		{
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
@@ -480,6 +642,23 @@ func TestFunctionLiteralParsing(t *testing.T) {
 	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
 }
 
+func TestFunctionLiteralWithName(t *testing.T) {
+	input := `let myFunction = fn() { };`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	require.Len(t, program.Statements, 1)
+	stmt, ok := program.Statements[0].(*ast.LetStatement)
+	require.True(t, ok, "program.Statements[0] is not ast.LetStatement.")
+
+	function, ok := stmt.Value.(*ast.FunctionLiteral)
+	require.True(t, ok, "stmt.Value is not ast.FunctionLiteral.")
+	assert.Equal(t, "myFunction", function.Name)
+}
+
 func TestFunctionParameterParsing(t *testing.T) {
 	tests := []struct {
 		input          string
@@ -595,6 +774,66 @@ func TestParsingIndexExpressions(t *testing.T) {
 	testInfixExpression(t, indexExp.Index, 1, "+", 1)
 }
 
+func TestParsingSliceExpressions(t *testing.T) {
+	tests := []struct {
+		input string
+		low   interface{}
+		high  interface{}
+	}{
+		{"s[1:2]", 1, 2},
+		{"s[1:]", 1, nil},
+		{"s[:2]", nil, 2},
+		{"s[:]", nil, nil},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		slice, ok := stmt.Expression.(*ast.SliceExpression)
+		require.True(t, ok, "exp not *ast.SliceExpression. got=%T", stmt.Expression)
+		testIdentifier(t, slice.Left, "s")
+		for _, bound := range []struct {
+			exp      ast.Expression
+			expected interface{}
+		}{{slice.Low, tt.low}, {slice.High, tt.high}} {
+			if bound.expected == nil {
+				assert.Nil(t, bound.exp, tt.input)
+			} else {
+				testLiteralExpression(t, bound.exp, bound.expected)
+			}
+		}
+	}
+}
+
+func TestParsingInterpolatedStrings(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedParts int
+		expected      string
+	}{
+		{`"total: ${a + b}!"`, 3, "total: ${(a + b)}!"},
+		{`"${a}${b}"`, 5, "${a}${b}"},
+		{`"${ {"k": 1}["k"] } ${"in ${x}"}"`, 5, "${({k:1}[k])} ${in ${x}}"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		str, ok := stmt.Expression.(*ast.InterpolatedString)
+		require.True(t, ok, "exp not *ast.InterpolatedString. got=%T", stmt.Expression)
+		assert.Len(t, str.Parts, tt.expectedParts, tt.input)
+		assert.Equal(t, tt.expected, str.String())
+		assert.Equal(t, "1:1", str.Pos().String())
+		assert.Equal(t, len(tt.input)+1, str.End().Column, tt.input)
+	}
+}
+
 func TestParsingHashLiteralsStringKeys(t *testing.T) {
 	input := `{"one": 1, "two": 2, "three": 3}`
 
@@ -612,19 +851,25 @@ func TestParsingHashLiteralsStringKeys(t *testing.T) {
 		t.Errorf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
 	}
 
-	expected := map[string]int64{
-		"one":   1,
-		"two":   2,
-		"three": 3,
+	expected := []struct {
+		key   string
+		value int64
+	}{
+		{"one", 1},
+		{"two", 2},
+		{"three", 3},
 	}
 
-	for key, value := range hash.Pairs {
-		literal, ok := key.(*ast.StringLiteral)
+	for i, pair := range hash.Pairs {
+		literal, ok := pair.Key.(*ast.StringLiteral)
 		if !ok {
-			t.Errorf("key is not ast.StringLiteral. got=%T", key)
+			t.Errorf("key is not ast.StringLiteral. got=%T", pair.Key)
+			continue
 		}
-		expectedValue := expected[literal.String()]
-		testIntegerLiteral(t, value, expectedValue)
+		if literal.String() != expected[i].key {
+			t.Errorf("pairs are not in source order. want=%q, got=%q", expected[i].key, literal.String())
+		}
+		testIntegerLiteral(t, pair.Value, expected[i].value)
 	}
 }
 
@@ -675,10 +920,10 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 		},
 	}
 
-	for key, value := range hash.Pairs {
-		literal, ok := key.(*ast.StringLiteral)
+	for _, pair := range hash.Pairs {
+		literal, ok := pair.Key.(*ast.StringLiteral)
 		if !ok {
-			t.Errorf("key is not ast.StringLiteral. got=%T", key)
+			t.Errorf("key is not ast.StringLiteral. got=%T", pair.Key)
 			continue
 		}
 		testFunc, ok := tests[literal.String()]
@@ -686,6 +931,333 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 			t.Errorf("No test function for key %q found", literal.String())
 			continue
 		}
-		testFunc(value)
+		testFunc(pair.Value)
+	}
+}
+
+func TestConstStatement(t *testing.T) {
+	l := lexer.New("const x = 5;")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	require.Len(t, program.Statements, 1)
+	stmt, ok := program.Statements[0].(*ast.LetStatement)
+	require.True(t, ok, "statement is not *ast.LetStatement. got=%T", program.Statements[0])
+	assert.True(t, stmt.IsConst())
+	assert.Equal(t, "const x = 5;", stmt.String())
+}
+
+func TestRedeclaration(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string // errors with Config.ForbidRedeclaration set
+	}{
+		{"let x = 1; let y = 2;", nil},
+		{"let x = 1; let x = 2;", []string{"1:16: x is already declared in this scope"}},
+		{"let x = 1; let f = fn(x) { let y = x; };", nil},
+		{"let f = fn(x) { let x = 1; };", []string{"1:21: x is already declared in this scope"}},
+		{"let f = fn() { let a = 1; }; let g = fn() { let a = 2; };", nil},
+		{"while (true) { let x = 1; }", nil},
+		{"const x = 1; let f = fn() { const x = 2; };", nil},
+		{"const x = 1; let x = 2;", []string{"1:18: cannot redeclare constant x"}},
+	}
+
+	for _, tt := range tests {
+		p := NewWithConfig(lexer.New(tt.input), Config{ForbidRedeclaration: true})
+		p.ParseProgram()
+		assert.Equal(t, len(tt.expected), len(p.Errors()), "wrong errors for %q: %v", tt.input, p.Errors())
+		for i, msg := range tt.expected {
+			if i < len(p.Errors()) {
+				assert.Equal(t, msg, p.Errors()[i])
+			}
+		}
+
+		// Without the option only redeclared constants are reported.
+		p = New(lexer.New(tt.input))
+		p.ParseProgram()
+		for _, msg := range p.Errors() {
+			assert.Contains(t, msg, "constant", "unexpected error for %q", tt.input)
+		}
+	}
+}
+
+func TestAssignExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x = 5", "(x = 5)"},
+		{"x += 1 + 2", "(x += (1 + 2))"},
+		{"a = b = c", "(a = (b = c))"},
+		{"arr[i] -= 1", "((arr[i]) -= 1)"},
+		{`h["k"] = fn(x) { x }`, "((h[k]) = fn(x) x)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		require.Len(t, program.Statements, 1, "wrong number of statements for %q", tt.input)
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		require.True(t, ok, "statement is not *ast.ExpressionStatement. got=%T", program.Statements[0])
+		_, ok = stmt.Expression.(*ast.AssignExpression)
+		require.True(t, ok, "expression is not *ast.AssignExpression. got=%T", stmt.Expression)
+		assert.Equal(t, tt.expected, program.String())
+	}
+}
+
+func TestLoopStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"while (x < 10) { x }", "while(x < 10) x"},
+		{"while (true) { break; continue; }", "whiletrue break;continue;"},
+		{"for (x in [1, 2]) { puts(x); }", "for(x in [1, 2]) puts(x)"},
+		{"for (x in xs) { while (x) { break; } }", "for(x in xs) whilex break;"},
+		{"while (x) { if (x) { if (y) { break } } else { continue } }", "whilex ifx ify break;else continue;"},
+		{"while (x) { let f = fn() { while (y) { if (y) { break } } } }", "whilex let f = fn() whiley ify break;;"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		require.Len(t, program.Statements, 1, "wrong number of statements for %q", tt.input)
+		assert.Equal(t, tt.expected, program.String())
+	}
+}
+
+func TestLoopControlInExpression(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"while (x) { let y = if (x) { break }; }", "1:30: break can't be used as a value"},
+		{"while (x) { puts(if (x) { continue }) }", "1:27: continue can't be used as a value"},
+		{"while (x) { if (x) { break } + 1 }", "1:22: break can't be used as a value"},
+		{"while (x) { return if (x) { break } }", "1:29: break can't be used as a value"},
+		{"while (x) { if (if (x) { break }) { 1 } }", "1:26: break can't be used as a value"},
+		{"while (x) { let y = if (x) { if (y) { break } } }", "1:39: break can't be used as a value"},
+		{"for (x in xs) { while (if (x) { break }) { } }", "1:33: break can't be used as a value"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		require.Len(t, p.Diagnostics(), 1, "wrong number of diagnostics for %q", tt.input)
+		assert.Equal(t, CodeControlInExpression, p.Diagnostics()[0].Code)
+		assert.Equal(t, tt.expectedError, p.Errors()[0])
+	}
+}
+
+func TestParserErrorPositions(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"let x 5;", "script.monkey:1:7: expected next token to be =, got INT instead"},
+		{"let x = 5;\nlet = 10;", "script.monkey:2:5: expected next token to be IDENT, got = instead"},
+		{"add(1,\n  ;", "script.monkey:2:3: no prefix parse function for ; found"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewFile("script.monkey", tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		require.NotEmpty(t, p.Errors(), "expected parser errors for %q", tt.input)
+		assert.Equal(t, tt.expectedError, p.Errors()[0])
+	}
+}
+
+func TestNodePositions(t *testing.T) {
+	input := "let add = fn(a, b) {\n  a + b\n};\nadd(1, [2][0])"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	require.Len(t, program.Statements, 2)
+
+	tests := []struct {
+		node          ast.Node
+		expectedStart string
+		expectedEnd   string
+	}{
+		{program, "1:1", "4:15"},
+		{program.Statements[0], "1:1", "3:2"},
+		{program.Statements[0].(*ast.LetStatement).Value, "1:11", "3:2"},
+		{program.Statements[0].(*ast.LetStatement).Value.(*ast.FunctionLiteral).Body.Statements[0], "2:3", "2:8"},
+		{program.Statements[1], "4:1", "4:15"},
+		{program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.CallExpression).Arguments[1], "4:8", "4:14"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expectedStart, tt.node.Pos().String(), "wrong start of %q", tt.node.String())
+		assert.Equal(t, tt.expectedEnd, tt.node.End().String(), "wrong end of %q", tt.node.String())
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	input := "let x = (1 + 2;"
+
+	l := lexer.New(input)
+	p := New(l)
+	p.ParseProgram()
+
+	diagnostics := p.Diagnostics()
+	require.NotEmpty(t, diagnostics)
+
+	d := diagnostics[0]
+	assert.Equal(t, SeverityError, d.Severity)
+	assert.Equal(t, CodeUnexpectedToken, d.Code)
+	assert.Equal(t, "expected next token to be ), got ; instead", d.Message)
+	assert.Equal(t, []token.Type{token.RPAREN}, d.Expected)
+	assert.Equal(t, token.Type(token.SEMICOLON), d.Actual)
+	assert.Equal(t, "1:15", d.Span.Start.String())
+	assert.Equal(t, "1:16", d.Span.End.String())
+	assert.NotEmpty(t, d.Hints)
+	assert.Equal(t, d.String(), p.Errors()[0])
+}
+
+func TestStringDiagnostics(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+		expectedEnd     string
+		expectedHints   int
+	}{
+		{"let s = \"abc;\nlet t = 1;", "unterminated string literal", "2:11", 1},
+		{"let s = `abc", "unterminated string literal", "1:13", 1},
+		{`let s = "a\qb";`, `invalid escape sequence \q`, "1:15", 0},
+		{`let s = "\u{110000}";`, `invalid Unicode code point \u{110000}`, "1:21", 0},
+		{`let s = "\u0041";`, `invalid Unicode escape sequence, want \u{...}`, "1:17", 0},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		require.NotEmpty(t, p.Diagnostics(), "expected diagnostics for %q", tt.input)
+		d := p.Diagnostics()[0]
+		assert.Equal(t, CodeInvalidString, d.Code)
+		assert.Equal(t, tt.expectedMessage, d.Message)
+		assert.Equal(t, "1:9", d.Span.Start.String())
+		assert.Equal(t, tt.expectedEnd, d.Span.End.String())
+		assert.Len(t, d.Hints, tt.expectedHints)
+	}
+}
+
+func TestDiagnosticCodes(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedCode Code
+	}{
+		{"let = 5;", CodeUnexpectedToken},
+		{"5 + ;", CodeExpectedExpression},
+		{"@", CodeExpectedExpression},
+		{"09", CodeInvalidInteger},
+		{"1e400", CodeInvalidFloat},
+		{"1_.5", CodeInvalidFloat},
+		{`"abc`, CodeInvalidString},
+		{`"a\qb"`, CodeInvalidString},
+		{`"${1} \q"`, CodeInvalidString},
+		{`"${1 2}"`, CodeUnexpectedToken},
+		{`"${}"`, CodeExpectedExpression},
+		{"break;", CodeOutsideLoop},
+		{"while (true) { fn() { continue; } }", CodeOutsideLoop},
+		{"while (true) { let x = if (true) { break } }", CodeControlInExpression},
+		{"1 = 2", CodeInvalidAssignment},
+		{"f() += 1", CodeInvalidAssignment},
+		{"const x = 1; let x = 2;", CodeRedeclared},
+		{"let x = 1; const x = 2; x = 3; const x = 4;", CodeRedeclared},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		require.NotEmpty(t, p.Diagnostics(), "expected diagnostics for %q", tt.input)
+		assert.Equal(t, tt.expectedCode, p.Diagnostics()[0].Code, "wrong code for %q", tt.input)
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	input := `let x 5;
+let y = 10;
+let = 3;
+let add = fn(a, b) {
+  let c = ;
+  a + b
+};
+let z = (1 + 2;
+add(1, 2
+let w = fn(a) { a + };
+w(1);`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+
+	expectedErrors := []string{
+		"1:7: expected next token to be =, got INT instead",
+		"3:5: expected next token to be IDENT, got = instead",
+		"5:11: no prefix parse function for ; found",
+		"8:15: expected next token to be ), got ; instead",
+		"10:1: expected next token to be ), got LET instead",
+		"10:21: no prefix parse function for } found",
+	}
+	assert.Equal(t, expectedErrors, p.Errors())
+
+	expectedStatements := []string{
+		"<bad statement>",
+		"let y = 10;",
+		"<bad statement>",
+		"let add = fn(a, b) <bad statement>(a + b);",
+		"<bad statement>",
+		"<bad statement>",
+		"let w = fn(a) <bad statement>;",
+		"w(1)",
+	}
+	require.Len(t, program.Statements, len(expectedStatements))
+	for i, stmt := range program.Statements {
+		assert.Equal(t, expectedStatements[i], stmt.String())
+	}
+
+	bad, ok := program.Statements[0].(*ast.BadStatement)
+	require.True(t, ok, "program.Statements[0] is not ast.BadStatement.")
+	assert.Equal(t, "1:1", bad.Pos().String())
+	assert.Equal(t, "1:9", bad.End().String())
+}
+
+func TestErrorRecoveryInBlocks(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedErrors int
+	}{
+		{"1; )", 1},
+		{"fn() { 1; ) }; 2", 1},
+		{"fn() { fn() { 1 + } + }; 3", 2},
+		{"if (x) { 1 } else 5; let y = 2;", 1},
+		{`{"a": fn() {} 5}; let q = 1;`, 1},
+		{"} let a = 1;", 1},
+		{"fn() { 1", 1},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+		assert.Len(t, p.Errors(), tt.expectedErrors, "wrong number of errors for %q: %v", tt.input, p.Errors())
 	}
 }
@@ -0,0 +1,79 @@
+package repl
+
+import (
+	"github.com/idexter/monkey/ast"
+	"github.com/idexter/monkey/compiler"
+	"github.com/idexter/monkey/evaluator"
+	"github.com/idexter/monkey/object"
+	"github.com/idexter/monkey/parser"
+	"github.com/idexter/monkey/vm"
+)
+
+// Engine selects how Monkey programs are executed.
+type Engine string
+
+const (
+	EngineEval Engine = "eval" // tree-walking evaluator
+	EngineVM   Engine = "vm"   // bytecode compiler and virtual machine
+)
+
+// Config selects the engine programs are run with and its settings.
+type Config struct {
+	Engine Engine
+	Parser parser.Config
+
+	// CheckedArithmetic and MaxCallDepth set the fields of the same names
+	// of the evaluator or the VM.
+	CheckedArithmetic bool
+	MaxCallDepth      int
+}
+
+// executor runs programs and returns their result.
+// Global definitions survive from one program to another.
+type executor func(program *ast.Program) object.Object
+
+func newExecutor(config Config) executor {
+	if config.Engine == EngineVM {
+		return newVMExecutor(config)
+	}
+
+	eval := &evaluator.Evaluator{
+		CheckedArithmetic: config.CheckedArithmetic,
+		MaxCallDepth:      config.MaxCallDepth,
+	}
+	env := object.NewEnvironment()
+	return func(program *ast.Program) object.Object {
+		return eval.Eval(program, env)
+	}
+}
+
+func newVMExecutor(config Config) executor {
+	constants := []object.Object{}
+	globals := make([]object.Object, vm.GlobalsSize)
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+
+	return func(program *ast.Program) object.Object {
+		comp := compiler.NewWithState(symbolTable, constants)
+		if err := comp.Compile(program); err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+
+		code := comp.Bytecode()
+		constants = code.Constants
+
+		machine := vm.NewWithGlobalsStore(code, globals)
+		machine.CheckedArithmetic = config.CheckedArithmetic
+		machine.MaxCallDepth = config.MaxCallDepth
+		if err := machine.Run(); err != nil {
+			if errObj, ok := err.(*object.Error); ok {
+				return errObj
+			}
+			return &object.Error{Message: err.Error()}
+		}
+
+		return machine.LastPoppedStackElem()
+	}
+}
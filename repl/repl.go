@@ -5,19 +5,21 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"strings"
+	"unicode/utf8"
 
-	"github.com/idexter/monkey/evaluator"
 	"github.com/idexter/monkey/lexer"
 	"github.com/idexter/monkey/object"
 	"github.com/idexter/monkey/parser"
+	"github.com/idexter/monkey/token"
 )
 
 const PROMPT = ">> "
 
-// StartREPL implements Read-Parse-Print-Loop.
-func StartREPL(in io.Reader, out io.Writer) {
+// StartREPL implements Read-Eval-Print-Loop.
+func StartREPL(in io.Reader, out io.Writer, config Config) {
 	scanner := bufio.NewScanner(in)
-	env := object.NewEnvironment()
+	execute := newExecutor(config)
 
 	for {
 		fmt.Printf(PROMPT)
@@ -28,31 +30,94 @@ func StartREPL(in io.Reader, out io.Writer) {
 
 		line := scanner.Text()
 		l := lexer.New(line)
-		p := parser.New(l)
+		p := parser.NewWithConfig(l, config.Parser)
 
 		program := p.ParseProgram()
 		if len(p.Errors()) != 0 {
-			printParseErrors(out, p.Errors())
+			printParseErrors(out, line, p.Diagnostics())
 			continue
 		}
+		printWarnings(out, line, p.Diagnostics())
 
-		evaluated := evaluator.Eval(program, env)
+		evaluated := execute(program)
 		if evaluated != nil {
-			io.WriteString(out, evaluated.Inspect())
-			io.WriteString(out, "\n")
+			printResult(out, evaluated)
 		}
 	}
 }
 
-func printParseErrors(out io.Writer, errors []string) {
+// printResult prints evaluated object, errors are followed by their stack trace.
+func printResult(out io.Writer, obj object.Object) {
+	io.WriteString(out, obj.Inspect())
+	io.WriteString(out, "\n")
+	if err, ok := obj.(*object.Error); ok {
+		io.WriteString(out, err.StackTrace())
+	}
+}
+
+func printParseErrors(out io.Writer, source string, diagnostics []parser.Diagnostic) {
 	io.WriteString(out, MONKEY_FACE)
 	io.WriteString(out, "Woops! We ran into some monkey business here!\n")
 	io.WriteString(out, " parser errors:\n")
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+	for _, d := range diagnostics {
+		io.WriteString(out, "\t"+d.String()+"\n")
+		io.WriteString(out, sourceExcerpt(source, d.Span, "\t\t"))
+		for _, hint := range d.Hints {
+			io.WriteString(out, "\t\thint: "+hint+"\n")
+		}
 	}
 }
 
+// printWarnings prints the diagnostics which don't stop the program from
+// running, like the use of deprecated syntax.
+func printWarnings(out io.Writer, source string, diagnostics []parser.Diagnostic) {
+	for _, d := range diagnostics {
+		if d.Severity != parser.SeverityWarning {
+			continue
+		}
+		io.WriteString(out, "warning: "+d.String()+"\n")
+		io.WriteString(out, sourceExcerpt(source, d.Span, "\t"))
+		for _, hint := range d.Hints {
+			io.WriteString(out, "\thint: "+hint+"\n")
+		}
+	}
+}
+
+// sourceExcerpt returns the source line where span starts
+// with carets under the spanned characters.
+func sourceExcerpt(source string, span token.Span, indent string) string {
+	start := span.Start
+	if !start.IsValid() || start.Offset > len(source) {
+		return ""
+	}
+
+	lineStart := strings.LastIndexByte(source[:start.Offset], '\n') + 1
+	lineEnd := strings.IndexByte(source[start.Offset:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(source)
+	} else {
+		lineEnd += start.Offset
+	}
+	line := source[lineStart:lineEnd]
+
+	// Keep tabs, so carets line up with the excerpt.
+	var padding strings.Builder
+	for _, ch := range source[lineStart:start.Offset] {
+		if ch == '\t' {
+			padding.WriteRune('\t')
+		} else {
+			padding.WriteRune(' ')
+		}
+	}
+
+	width := 1
+	if span.End.Line == start.Line && span.End.Offset > start.Offset && span.End.Offset <= len(source) {
+		width = utf8.RuneCountInString(source[start.Offset:span.End.Offset])
+	}
+
+	return indent + line + "\n" + indent + padding.String() + strings.Repeat("^", width) + "\n"
+}
+
 const MONKEY_FACE = `            __,__
    .--.  .-"     "-.  .--.
   / .. \/  .-. .-.  \/ .. \
@@ -67,26 +132,26 @@ const MONKEY_FACE = `            __,__
 `
 
 // RunScript runs script from byte array.
-func RunScript(in io.Reader, out io.Writer) {
+// The filename is used to report positions in errors.
+func RunScript(filename string, in io.Reader, out io.Writer, config Config) {
 	script, err := ioutil.ReadAll(in)
 	if err != nil {
 		fmt.Printf("Unable to read script: %v\n", err)
 		return
 	}
 
-	env := object.NewEnvironment()
-	l := lexer.New(string(script))
-	p := parser.New(l)
+	l := lexer.NewFile(filename, string(script))
+	p := parser.NewWithConfig(l, config.Parser)
 
 	program := p.ParseProgram()
 	if len(p.Errors()) != 0 {
-		printParseErrors(out, p.Errors())
+		printParseErrors(out, string(script), p.Diagnostics())
 		return
 	}
+	printWarnings(out, string(script), p.Diagnostics())
 
-	evaluated := evaluator.Eval(program, env)
+	evaluated := newExecutor(config)(program)
 	if evaluated != nil {
-		io.WriteString(out, evaluated.Inspect())
-		io.WriteString(out, "\n")
+		printResult(out, evaluated)
 	}
 }
@@ -26,7 +26,7 @@ func StartRPPL(in io.Reader, out io.Writer) {
 
 		program := p.ParseProgram()
 		if len(p.Errors()) != 0 {
-			printParseErrors(out, p.Errors())
+			printParseErrors(out, line, p.Diagnostics())
 			continue
 		}
 
@@ -0,0 +1,74 @@
+package vm
+
+import (
+	"github.com/idexter/monkey/code"
+	"github.com/idexter/monkey/object"
+	"github.com/idexter/monkey/token"
+)
+
+// Frame holds execution state of a function call.
+type Frame struct {
+	cl          *object.Closure
+	ip          int
+	basePointer int
+}
+
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{
+		cl:          cl,
+		ip:          -1,
+		basePointer: basePointer,
+	}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
+
+// position returns source position of the instruction being executed.
+func (f *Frame) position() token.Position {
+	// The instruction pointer may point to an operand,
+	// so look for the start of the instruction.
+	for ip := f.ip; ip >= 0; ip-- {
+		if pos, ok := f.cl.Fn.Positions[ip]; ok {
+			return pos
+		}
+	}
+	return token.Position{}
+}
+
+// iterator is kept on the stack while a for-in loop runs.
+type iterator struct {
+	values []object.Object
+	next   int // index of the next value
+}
+
+func (it *iterator) Type() object.Type { return "ITERATOR" }
+func (it *iterator) Inspect() string   { return "iterator" }
+
+// cell holds a variable shared by a function and the closures capturing it.
+type cell struct {
+	value object.Object
+}
+
+func (c *cell) Type() object.Type { return "CELL" }
+func (c *cell) Inspect() string   { return "cell" }
+
+// load returns the value of a variable, which may be stored in a cell.
+func load(obj object.Object) object.Object {
+	if c, ok := obj.(*cell); ok {
+		return c.value
+	}
+	return obj
+}
+
+// capture returns the cell of the variable stored at slot, moving the value
+// into a new cell if the variable hasn't been captured yet.
+func capture(slot *object.Object) *cell {
+	if c, ok := (*slot).(*cell); ok {
+		return c
+	}
+	c := &cell{value: *slot}
+	*slot = c
+	return c
+}
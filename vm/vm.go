@@ -0,0 +1,889 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/idexter/monkey/code"
+	"github.com/idexter/monkey/compiler"
+	"github.com/idexter/monkey/object"
+)
+
+const (
+	StackSize    = 2048 // initial size of the stack, it grows as needed
+	MaxStackSize = 1 << 22
+	GlobalsSize  = 65536
+	FramesSize   = 64 // initial number of frames, they grow as needed
+
+	DefaultMaxCallDepth = 10000 // MaxCallDepth of a VM made by New
+)
+
+var (
+	True  = object.TRUE
+	False = object.FALSE
+	Null  = &object.Null{}
+)
+
+// VM executes bytecode produced by the compiler package.
+type VM struct {
+	constants []object.Object
+
+	stack []object.Object
+	sp    int // Always points to the next value. Top of stack is stack[sp-1]
+
+	globals []object.Object
+
+	frames      []*Frame
+	framesIndex int
+
+	// CheckedArithmetic and MaxCallDepth work the same as the evaluator's:
+	// one makes overflow of int64 an error, the other limits the number of
+	// nested function calls.
+	CheckedArithmetic bool
+	MaxCallDepth      int
+}
+
+func New(bytecode *compiler.Bytecode) *VM {
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Positions:    bytecode.Positions,
+		Names:        bytecode.Names,
+	}
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0)
+
+	frames := make([]*Frame, 1, FramesSize)
+	frames[0] = mainFrame
+
+	return &VM{
+		constants:   bytecode.Constants,
+		stack:       make([]object.Object, StackSize),
+		sp:          0,
+		globals:     make([]object.Object, GlobalsSize),
+		frames:      frames,
+		framesIndex: 1,
+
+		MaxCallDepth: DefaultMaxCallDepth,
+	}
+}
+
+// NewWithGlobalsStore creates VM which keeps globals
+// of previous runs, e.g. in REPL.
+func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
+	vm := New(bytecode)
+	vm.globals = s
+	return vm
+}
+
+// LastPoppedStackElem returns value of the last executed expression statement.
+func (vm *VM) LastPoppedStackElem() object.Object {
+	return vm.stack[vm.sp]
+}
+
+func (vm *VM) Run() error {
+	err := vm.run(0)
+	if errObj, ok := err.(*object.Error); ok {
+		vm.unwind(errObj, 1)
+	}
+	return err
+}
+
+// run executes instructions until the program ends or the frame at index
+// stopAt returns.
+func (vm *VM) run(stopAt int) error {
+	var ip int
+	var ins code.Instructions
+	var op code.Opcode
+
+	for vm.framesIndex > stopAt && vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		vm.currentFrame().ip++
+
+		ip = vm.currentFrame().ip
+		ins = vm.currentFrame().Instructions()
+		op = code.Opcode(ins[ip])
+
+		switch op {
+		case code.OpConstant:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			if err := vm.push(vm.constants[constIndex]); err != nil {
+				return err
+			}
+
+		case code.OpPop:
+			vm.pop()
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod, code.OpPow,
+			code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShiftLeft, code.OpShiftRight,
+			code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan,
+			code.OpGreaterEqual, code.OpLessEqual:
+			if err := vm.executeBinaryOperation(op); err != nil {
+				return err
+			}
+
+		case code.OpTrue:
+			if err := vm.push(True); err != nil {
+				return err
+			}
+
+		case code.OpFalse:
+			if err := vm.push(False); err != nil {
+				return err
+			}
+
+		case code.OpNull:
+			if err := vm.push(Null); err != nil {
+				return err
+			}
+
+		case code.OpBang:
+			if err := vm.executeBangOperator(); err != nil {
+				return err
+			}
+
+		case code.OpMinus:
+			if err := vm.executeMinusOperator(); err != nil {
+				return err
+			}
+
+		case code.OpJump:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip = pos - 1
+
+		case code.OpJumpNotTruthy:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			condition := vm.pop()
+			if !isTruthy(condition) {
+				vm.currentFrame().ip = pos - 1
+			}
+
+		case code.OpIterator:
+			iterable := vm.pop()
+			values, ok := object.Iterate(iterable)
+			if !ok {
+				return newError("not iterable: %s", iterable.Type())
+			}
+			if err := vm.push(&iterator{values: values}); err != nil {
+				return err
+			}
+
+		case code.OpIterNext:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			it := vm.stack[vm.sp-1].(*iterator)
+			if it.next >= len(it.values) {
+				vm.pop()
+				vm.currentFrame().ip = pos - 1
+				continue
+			}
+			it.next++
+			if err := vm.push(it.values[it.next-1]); err != nil {
+				return err
+			}
+
+		case code.OpSetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			if c, ok := vm.globals[globalIndex].(*cell); ok {
+				c.value = vm.pop()
+			} else {
+				vm.globals[globalIndex] = vm.pop()
+			}
+
+		case code.OpDefineGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			vm.globals[globalIndex] = vm.pop()
+
+		case code.OpGetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			global := load(vm.globals[globalIndex])
+			if global == nil {
+				return vm.notFound(ip)
+			}
+			if err := vm.push(global); err != nil {
+				return err
+			}
+
+		case code.OpSetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			slot := frame.basePointer + int(localIndex)
+			if c, ok := vm.stack[slot].(*cell); ok {
+				c.value = vm.pop()
+			} else {
+				vm.stack[slot] = vm.pop()
+			}
+
+		case code.OpDefineLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+
+		case code.OpGetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			local := load(vm.stack[frame.basePointer+int(localIndex)])
+			if local == nil {
+				return vm.notFound(ip)
+			}
+			if err := vm.push(local); err != nil {
+				return err
+			}
+
+		case code.OpGetBuiltin:
+			builtinIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			definition := object.Builtins[builtinIndex]
+			if err := vm.push(definition.Builtin); err != nil {
+				return err
+			}
+
+		case code.OpGetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			currentClosure := vm.currentFrame().cl
+			free := load(currentClosure.Free[freeIndex])
+			if free == nil {
+				return vm.notFound(ip)
+			}
+			if err := vm.push(free); err != nil {
+				return err
+			}
+
+		case code.OpSetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			currentClosure := vm.currentFrame().cl
+			if c, ok := currentClosure.Free[freeIndex].(*cell); ok {
+				c.value = vm.pop()
+			} else {
+				currentClosure.Free[freeIndex] = vm.pop()
+			}
+
+		case code.OpCaptureGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			if err := vm.push(capture(&vm.globals[globalIndex])); err != nil {
+				return err
+			}
+
+		case code.OpCaptureLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			slot := &vm.stack[frame.basePointer+int(localIndex)]
+			if err := vm.push(capture(slot)); err != nil {
+				return err
+			}
+
+		case code.OpCaptureFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			currentClosure := vm.currentFrame().cl
+			if err := vm.push(capture(&currentClosure.Free[freeIndex])); err != nil {
+				return err
+			}
+
+		case code.OpCurrentClosure:
+			currentClosure := vm.currentFrame().cl
+			if err := vm.push(currentClosure); err != nil {
+				return err
+			}
+
+		case code.OpArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			array := vm.buildArray(vm.sp-numElements, vm.sp)
+			vm.sp = vm.sp - numElements
+
+			if err := vm.push(array); err != nil {
+				return err
+			}
+
+		case code.OpInterpolate:
+			numParts := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			str := object.Interpolate(vm.stack[vm.sp-numParts : vm.sp])
+			vm.sp = vm.sp - numParts
+
+			if err := vm.push(str); err != nil {
+				return err
+			}
+
+		case code.OpHash:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
+			if err != nil {
+				return err
+			}
+			vm.sp = vm.sp - numElements
+
+			if err := vm.push(hash); err != nil {
+				return err
+			}
+
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+
+			if err := vm.executeIndexExpression(left, index); err != nil {
+				return err
+			}
+
+		case code.OpSlice:
+			high := vm.pop()
+			low := vm.pop()
+			left := vm.pop()
+
+			result, err := object.Slice(left, low, high)
+			if err != nil {
+				return err
+			}
+			if err := vm.push(result); err != nil {
+				return err
+			}
+
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+
+			if err := object.SetIndex(left, index, value); err != nil {
+				return err
+			}
+			if err := vm.push(value); err != nil {
+				return err
+			}
+
+		case code.OpDup2:
+			if err := vm.push(vm.stack[vm.sp-2]); err != nil {
+				return err
+			}
+			if err := vm.push(vm.stack[vm.sp-2]); err != nil {
+				return err
+			}
+
+		case code.OpCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			if err := vm.executeCall(int(numArgs)); err != nil {
+				return err
+			}
+
+		case code.OpTailCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			if err := vm.executeTailCall(int(numArgs)); err != nil {
+				return err
+			}
+
+		case code.OpReturnValue:
+			returnValue := vm.pop()
+
+			if vm.framesIndex == 1 {
+				// Return at the top level stops the program.
+				vm.stack[vm.sp] = returnValue
+				return nil
+			}
+
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+
+			if err := vm.push(returnValue); err != nil {
+				return err
+			}
+
+		case code.OpReturn:
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+
+			if err := vm.push(Null); err != nil {
+				return err
+			}
+
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			numFree := code.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+
+			if err := vm.pushClosure(int(constIndex), int(numFree)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// unwind records where the error occurred and the calls down to the frame at
+// index base it unwound through, and drops the frames of those calls.
+func (vm *VM) unwind(err *object.Error, base int) {
+	if !err.Pos.IsValid() {
+		err.Pos = vm.currentFrame().position()
+	}
+	for i := vm.framesIndex - 1; i >= base; i-- {
+		name := closureName(vm.frames[i].cl)
+		err.Stack = append(err.Stack, object.Frame{Function: name, Pos: vm.frames[i-1].position()})
+	}
+	vm.framesIndex = base
+}
+
+// notFound reports that the variable read by the instruction at ip has no
+// value, because it is declared only in code which hasn't run.
+func (vm *VM) notFound(ip int) error {
+	return newError("identifier not found: %s", vm.currentFrame().cl.Fn.Names[ip])
+}
+
+// callFailed records a call which failed before or while running the
+// callee on the stack of err, since the callee has no frame to be found in.
+func (vm *VM) callFailed(err *object.Error, name string) error {
+	pos := vm.currentFrame().position()
+	if !err.Pos.IsValid() {
+		err.Pos = pos // errors of functions called by builtins have their own
+	}
+	err.Stack = append(err.Stack, object.Frame{Function: name, Pos: pos})
+	return err
+}
+
+// callFunction calls fn on behalf of a builtin and runs it to completion.
+// Errors unwind only the frames of this call, and are returned to the
+// builtin like any other result.
+func (vm *VM) callFunction(fn object.Object, args ...object.Object) object.Object {
+	base, sp := vm.framesIndex, vm.sp
+	err := vm.push(fn)
+	for _, arg := range args {
+		if err == nil {
+			err = vm.push(arg)
+		}
+	}
+	if err == nil {
+		err = vm.executeCall(len(args))
+	}
+	// Closures are run here, builtins have already pushed their result.
+	if err == nil {
+		err = vm.run(base)
+	}
+	if err != nil {
+		errObj, ok := err.(*object.Error)
+		if !ok {
+			errObj = newError("%s", err)
+		}
+		vm.unwind(errObj, base)
+		vm.sp = sp
+		return errObj
+	}
+	return vm.pop()
+}
+
+func (vm *VM) push(o object.Object) error {
+	if vm.sp >= len(vm.stack) {
+		if err := vm.growStack(vm.sp + 1); err != nil {
+			return err
+		}
+	}
+
+	vm.stack[vm.sp] = o
+	vm.sp++
+
+	return nil
+}
+
+// growStack makes room for at least size values on the stack.
+func (vm *VM) growStack(size int) *object.Error {
+	if size > MaxStackSize {
+		return newError("stack overflow")
+	}
+	newSize := 2 * len(vm.stack)
+	for newSize < size {
+		newSize *= 2
+	}
+	if newSize > MaxStackSize {
+		newSize = MaxStackSize
+	}
+	stack := make([]object.Object, newSize)
+	copy(stack, vm.stack)
+	vm.stack = stack
+	return nil
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) {
+	if vm.framesIndex == len(vm.frames) {
+		vm.frames = append(vm.frames, f)
+	} else {
+		vm.frames[vm.framesIndex] = f
+	}
+	vm.framesIndex++
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
+var binaryOperators = map[code.Opcode]string{
+	code.OpAdd:          "+",
+	code.OpSub:          "-",
+	code.OpMul:          "*",
+	code.OpDiv:          "/",
+	code.OpMod:          "%",
+	code.OpPow:          "**",
+	code.OpBitAnd:       "&",
+	code.OpBitOr:        "|",
+	code.OpBitXor:       "^",
+	code.OpShiftLeft:    "<<",
+	code.OpShiftRight:   ">>",
+	code.OpEqual:        "==",
+	code.OpNotEqual:     "!=",
+	code.OpGreaterThan:  ">",
+	code.OpLessThan:     "<",
+	code.OpGreaterEqual: ">=",
+	code.OpLessEqual:    "<=",
+}
+
+// executeBinaryOperation follows the same rules as infix expressions in the evaluator.
+func (vm *VM) executeBinaryOperation(op code.Opcode) error {
+	right := vm.pop()
+	left := vm.pop()
+	operator := binaryOperators[op]
+
+	switch {
+	case op == code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(object.Equal(left, right)))
+	case op == code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(!object.Equal(left, right)))
+	case left.Type() == object.FLOAT_OBJ && isNumber(right), right.Type() == object.FLOAT_OBJ && isNumber(left):
+		return vm.executeFloatOperation(operator, left, right)
+	case left.Type() != right.Type():
+		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
+	case left.Type() == object.INTEGER_OBJ:
+		return vm.executeIntegerOperation(operator, left, right)
+	case left.Type() == object.STRING_OBJ:
+		return vm.executeStringOperation(operator, left, right)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func (vm *VM) executeIntegerOperation(operator string, left, right object.Object) error {
+	switch operator {
+	case "+", "-", "*", "/", "%", "**", "&", "|", "^", "<<", ">>":
+		result, err := object.IntegerArithmetic(operator, left, right, vm.CheckedArithmetic)
+		if err != nil {
+			return err
+		}
+		return vm.push(result)
+	case "<":
+		return vm.push(nativeBoolToBooleanObject(object.CompareIntegers(left, right) < 0))
+	case ">":
+		return vm.push(nativeBoolToBooleanObject(object.CompareIntegers(left, right) > 0))
+	case "<=":
+		return vm.push(nativeBoolToBooleanObject(object.CompareIntegers(left, right) <= 0))
+	case ">=":
+		return vm.push(nativeBoolToBooleanObject(object.CompareIntegers(left, right) >= 0))
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func (vm *VM) executeFloatOperation(operator string, left, right object.Object) error {
+	leftVal, _ := object.ToFloat(left)
+	rightVal, _ := object.ToFloat(right)
+
+	switch operator {
+	case "+", "-", "*", "/", "%", "**":
+		result, err := object.FloatArithmetic(operator, leftVal, rightVal)
+		if err != nil {
+			return err
+		}
+		return vm.push(&object.Float{Value: result})
+	case "<":
+		return vm.push(nativeBoolToBooleanObject(leftVal < rightVal))
+	case ">":
+		return vm.push(nativeBoolToBooleanObject(leftVal > rightVal))
+	case "<=":
+		return vm.push(nativeBoolToBooleanObject(leftVal <= rightVal))
+	case ">=":
+		return vm.push(nativeBoolToBooleanObject(leftVal >= rightVal))
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+func (vm *VM) executeStringOperation(operator string, left, right object.Object) error {
+	leftVal := left.(*object.String).Value
+	rightVal := right.(*object.String).Value
+
+	switch operator {
+	case "+":
+		return vm.push(&object.String{Value: leftVal + rightVal})
+	case "<":
+		return vm.push(nativeBoolToBooleanObject(leftVal < rightVal))
+	case ">":
+		return vm.push(nativeBoolToBooleanObject(leftVal > rightVal))
+	case "<=":
+		return vm.push(nativeBoolToBooleanObject(leftVal <= rightVal))
+	case ">=":
+		return vm.push(nativeBoolToBooleanObject(leftVal >= rightVal))
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func (vm *VM) executeBangOperator() error {
+	operand := vm.pop()
+
+	switch operand {
+	case True:
+		return vm.push(False)
+	case False:
+		return vm.push(True)
+	case Null:
+		return vm.push(True)
+	default:
+		return vm.push(False)
+	}
+}
+
+func (vm *VM) executeMinusOperator() error {
+	operand := vm.pop()
+
+	if operand.Type() == object.FLOAT_OBJ {
+		return vm.push(&object.Float{Value: -operand.(*object.Float).Value})
+	}
+	if operand.Type() != object.INTEGER_OBJ {
+		return newError("unknown operator: -%s", operand.Type())
+	}
+
+	result, err := object.NegateInteger(operand, vm.CheckedArithmetic)
+	if err != nil {
+		return err
+	}
+	return vm.push(result)
+}
+
+func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
+	elements := make([]object.Object, endIndex-startIndex)
+
+	for i := startIndex; i < endIndex; i++ {
+		elements[i-startIndex] = vm.stack[i]
+	}
+
+	return &object.Array{Elements: elements}
+}
+
+func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
+	hash := &object.Hash{}
+
+	for i := startIndex; i < endIndex; i += 2 {
+		if err := hash.Set(vm.stack[i], vm.stack[i+1]); err != nil {
+			return nil, err
+		}
+	}
+
+	return hash, nil
+}
+
+func (vm *VM) executeIndexExpression(left, index object.Object) error {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeArrayIndex(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		if char, ok := object.StringIndex(left.(*object.String), index); ok {
+			return vm.push(char)
+		}
+		return vm.push(Null)
+	case left.Type() == object.HASH_OBJ:
+		return vm.executeHashIndex(left, index)
+	default:
+		return newError("index operator not supported: %s", left.Type())
+	}
+}
+
+func (vm *VM) executeArrayIndex(array, index object.Object) error {
+	arrayObject := array.(*object.Array)
+	integer, ok := index.(*object.Integer)
+	if !ok {
+		return vm.push(Null) // a big integer is out of range of any array
+	}
+	i := integer.Value
+	max := int64(len(arrayObject.Elements) - 1)
+
+	if i < 0 || i > max {
+		return vm.push(Null)
+	}
+
+	return vm.push(arrayObject.Elements[i])
+}
+
+func (vm *VM) executeHashIndex(hash, index object.Object) error {
+	value, ok, err := hash.(*object.Hash).Get(index)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return vm.push(Null)
+	}
+
+	return vm.push(value)
+}
+
+func (vm *VM) executeCall(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.Builtin:
+		return vm.callBuiltin(callee, numArgs)
+	default:
+		return newError("not a function: %s", callee.Type())
+	}
+}
+
+// executeTailCall makes a call whose result the current function returns.
+// A closure replaces the current function on the stack and takes over its
+// frame, so tail calls don't nest and tail recursion runs in constant space.
+// Other calls are made as usual and their result is returned by the
+// OpReturnValue which follows.
+func (vm *VM) executeTailCall(numArgs int) error {
+	cl, ok := vm.stack[vm.sp-1-numArgs].(*object.Closure)
+	if !ok || numArgs != cl.Fn.NumParameters || vm.framesIndex == 1 {
+		return vm.executeCall(numArgs)
+	}
+
+	frame := vm.popFrame()
+	base := frame.basePointer - 1 // where the current closure is
+	copy(vm.stack[base:], vm.stack[vm.sp-1-numArgs:vm.sp])
+	vm.sp = base + 1 + numArgs
+	return vm.callClosure(cl, numArgs)
+}
+
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
+	if numArgs != cl.Fn.NumParameters {
+		err := newError("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
+		return vm.callFailed(err, closureName(cl))
+	}
+
+	if vm.framesIndex > vm.MaxCallDepth {
+		err := newError("maximum recursion depth exceeded calling %s", closureName(cl))
+		return vm.callFailed(err, closureName(cl))
+	}
+
+	frame := NewFrame(cl, vm.sp-numArgs)
+	if size := frame.basePointer + cl.Fn.NumLocals; size > len(vm.stack) {
+		if err := vm.growStack(size); err != nil {
+			return vm.callFailed(err, closureName(cl))
+		}
+	}
+	vm.pushFrame(frame)
+	vm.sp = frame.basePointer + cl.Fn.NumLocals
+	// Locals must not start with cells left behind by earlier calls.
+	for i := frame.basePointer + numArgs; i < vm.sp; i++ {
+		vm.stack[i] = nil
+	}
+
+	return nil
+}
+
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
+	args := vm.stack[vm.sp-numArgs : vm.sp]
+
+	result := builtin.Fn(vm.callFunction, args...)
+	if err, ok := result.(*object.Error); ok {
+		return vm.callFailed(err, builtinName(builtin))
+	}
+
+	vm.sp = vm.sp - numArgs - 1
+
+	if result != nil {
+		return vm.push(result)
+	}
+	return vm.push(Null)
+}
+
+func (vm *VM) pushClosure(constIndex int, numFree int) error {
+	constant := vm.constants[constIndex]
+	function, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %+v", constant)
+	}
+
+	free := make([]object.Object, numFree)
+	for i := 0; i < numFree; i++ {
+		free[i] = vm.stack[vm.sp-numFree+i]
+	}
+	vm.sp = vm.sp - numFree
+
+	closure := &object.Closure{Fn: function, Free: free}
+	return vm.push(closure)
+}
+
+func closureName(cl *object.Closure) string {
+	if cl.Fn.Name == "" {
+		return "<anonymous>"
+	}
+	return cl.Fn.Name
+}
+
+func builtinName(builtin *object.Builtin) string {
+	return object.GetBuiltinName(builtin)
+}
+
+func isTruthy(obj object.Object) bool {
+	switch obj {
+	case Null, False:
+		return false
+	default:
+		return true
+	}
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return True
+	}
+	return False
+}
+
+func newError(format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...)}
+}
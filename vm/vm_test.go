@@ -0,0 +1,602 @@
+package vm
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/idexter/monkey/ast"
+	"github.com/idexter/monkey/compiler"
+	"github.com/idexter/monkey/evaluator"
+	"github.com/idexter/monkey/lexer"
+	"github.com/idexter/monkey/object"
+	"github.com/idexter/monkey/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntegerArithmetic(t *testing.T) {
+	runVMTests(t, []vmTestCase{
+		{"1", 1},
+		{"1 + 2", 3},
+		{"50 / 2 * 2 + 10 - 5", 55},
+		{"5 * (2 + 10)", 60},
+		{"-50 + 100 + -50", 0},
+		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"17 % 5", 2},
+		{"0xff - 0O7 * 0B11", 234},
+		{"1_000 + 0x_10", 1016},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"12 & 10 | 1", 9},
+		{"12 ^ 10", 6},
+		{"1 << 10 >> 2", 256},
+		{"2 ** 64", bigInt("18446744073709551616")},
+	})
+}
+
+func TestFloatArithmetic(t *testing.T) {
+	runVMTests(t, []vmTestCase{
+		{"2.5", 2.5},
+		{"-2.5", -2.5},
+		{"1 + 0.5", 1.5},
+		{"3 / 2.0", 1.5},
+		{"(1 + 2) * 0.5", 1.5},
+		{"1 == 1.0", true},
+		{"1 < 1.5", true},
+		{"float(2) / 4", 0.5},
+		{"int(2.5) + 1", 3},
+		{"7.5 % 2", 1.5},
+		{"4 ** -1.0", 0.25},
+	})
+}
+
+func TestBigIntegers(t *testing.T) {
+	runVMTests(t, []vmTestCase{
+		{"99999999999999999999", bigInt("99999999999999999999")},
+		{"(-9223372036854775807 - 1) / -1", bigInt("9223372036854775808")},
+		{"-(-9223372036854775807 - 1)", bigInt("9223372036854775808")},
+		{"9223372036854775808 - 1", 9223372036854775807},
+		{"9223372036854775808 == 9223372036854775807 + 1", true},
+		{"[1, 2][9223372036854775808]", Null},
+		{`
+		let factorial = fn(n) { if (n == 0) { 1 } else { n * factorial(n - 1) } };
+		factorial(50)`, bigInt("30414093201713378043612608166064768844377641568960512000000000000")},
+	})
+}
+
+func TestBooleanExpressions(t *testing.T) {
+	runVMTests(t, []vmTestCase{
+		{"true", true},
+		{"1 < 2", true},
+		{"1 > 2", false},
+		{"1 == 1", true},
+		{"true != false", true},
+		{"(1 < 2) == true", true},
+		{"!5", false},
+		{"!!5", true},
+		{"!(if (false) { 5; })", true},
+		{"1 <= 1", true},
+		{"2 >= 2.5", false},
+		{`"a" < "b"`, true},
+		{`"abc" >= "abd"`, false},
+		{`"mon" + "key" == "monkey"`, true},
+		{`"a" != "a"`, false},
+		{"[1, 2] == [1, 2]", true},
+		{"[1, [2]] == [1, [2.0]]", true},
+		{`{"a": [1], 2: "b"} == {2: "b", "a": [1]}`, true},
+		{"let a = [1]; let b = [1]; a[0] = a; b[0] = b; a == b", true},
+		{`let a = {"x": 1}; let b = {"x": 1}; a["self"] = a; b["self"] = b; a == b`, true},
+		{"if (false) { 1 } == if (false) { 2 }", true},
+		{`1 != "1"`, true},
+		{"let f = fn() { 1 }; f == f", true},
+		{`{1: "one"}[1.0] == "one"`, true},
+		{"true && false", false},
+		{"1 && \"a\"", true},
+		{"false || 0", true},
+		{"if (false) { 1 } || false", false},
+		{"1 < 2 && 2 < 3 || false", true},
+		{"let called = false; let f = fn() { called = true }; false && f(); called", false},
+		{"let called = false; let f = fn() { called = true }; true || f(); called", false},
+		{"let called = false; let f = fn() { called = true }; true && f(); called", true},
+	})
+}
+
+func TestConditionals(t *testing.T) {
+	runVMTests(t, []vmTestCase{
+		{"if (true) { 10 }", 10},
+		{"if (1 > 2) { 10 } else { 20 }", 20},
+		{"if (1 > 2) { 10 }", Null},
+		{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
+	})
+}
+
+func TestGlobalLetStatements(t *testing.T) {
+	runVMTests(t, []vmTestCase{
+		{"let one = 1; one", 1},
+		{"let one = 1; let two = one + one; one + two", 3},
+		{"let a = 1; let a = a + 1; a", 2},
+	})
+}
+
+func TestLoops(t *testing.T) {
+	runVMTests(t, []vmTestCase{
+		{"let i = 0; while (i < 5) { let i = i + 1; } i", 5},
+		{"let i = 0; while (true) { let i = i + 1; if (i == 3) { break; } } i", 3},
+		{"let s = 0; for (x in [1, 2, 3, 4]) { if (x == 2) { continue; } let s = s + x; } s", 8},
+		{`let n = 0; for (c in "abc") { let n = n + 1; } n`, 3},
+		{"let f = fn() { let s = 0; for (x in [1, 2]) { for (y in [10, 20]) { let s = s + x * y; } } s }; f()", 90},
+		{"let f = fn() { for (x in [1, 2, 3]) { if (x == 2) { return x * 10; } } }; f()", 20},
+		{`let s = ""; for (k in {"b": 1, "a": 2, "c": 3}) { let s = s + k; } s`, "bac"},
+		{"while (false) { 1 }", Null},
+	})
+}
+
+func TestAssignments(t *testing.T) {
+	runVMTests(t, []vmTestCase{
+		{"let x = 1; x = 5; x", 5},
+		{"let x = 1; x += 4; x *= 3; x -= 1; x /= 2; x", 7},
+		{"let x = 0; let y = 0; x = y = 3; x + y", 6},
+		{"let x = 1; let f = fn() { x = 10; }; f(); x", 10},
+		{"let f = fn() { let x = 1; let g = fn() { x += 1 }; g(); g(); x }; f()", 3},
+		{"let counter = fn() { let c = 0; fn() { c += 1 } }; let next = counter(); next(); next()", 2},
+		{"let f = fn() { let n = 0; let inc = fn() { fn() { n += 1 } }; inc()(); inc()(); n }; f()", 2},
+		{"let f = fn() { let n = 0; let fs = [fn() { n += 1 }, fn() { n }]; fs[0](); fs[0](); fs[1]() }; f()", 2},
+		{"let f = fn() { f = 5; }; f(); f", 5},
+		{"let a = [1, 2, 3]; a[1] = 20; a[2] *= 5; a[0] + a[1] + a[2]", 36},
+		{`let h = {"a": 1}; h["a"] += 1; h["b"] = 10; h["a"] + h["b"]`, 12},
+		{"let a = [1]; let b = a; b[0] = 2; a[0]", 2},
+		{"const x = 1; let f = fn() { let x = 2; x }; f() + x", 3},
+		{"let f = fn() { const x = 1; fn() { x } }; f()()", 1},
+	})
+}
+
+func TestCollections(t *testing.T) {
+	runVMTests(t, []vmTestCase{
+		{`"mon" + "key"`, "monkey"},
+		{"[1 + 2, 3 * 4][1]", 12},
+		{"[1, 2, 3][3]", Null},
+		{"[][0]", Null},
+		{"{1: 2, 2: 3}[2]", 3},
+		{`{"a": 1}["b"]`, Null},
+		{`"abc"[1]`, "b"},
+		{`"abc"[5]`, Null},
+		{`"héllo"[1]`, "é"},
+		{`"héllo"[1:3]`, "él"},
+		{`len("héllo")`, 5},
+		{`len(bytes("héllo"))`, 6},
+		{`let café = 1; café + 1`, 2},
+		{`"abcde"[1:3]`, "bc"},
+		{`"abcde"[:2] + "abcde"[-1:]`, "abe"},
+		{"len([1, 2, 3][1:])", 2},
+		{"let h = {[2024, 1]: 5}; h[[2024, 1]]", 5},
+		{`{{"a": [1], "b": 2}: 3}[{"b": 2, "a": [1]}]`, 3},
+		{"let k = [1]; let h = {}; h[k] = 1; k[0] = 2; h[[1]]", 1},
+		{"{1: 2}[1.0]", 2},
+	})
+}
+
+func TestCallingFunctions(t *testing.T) {
+	runVMTests(t, []vmTestCase{
+		{"let f = fn() { 5 + 10; }; f();", 15},
+		{"let f = fn() { return 99; 100; }; f();", 99},
+		{"let noReturn = fn() { }; noReturn();", Null},
+		{"let sum = fn(a, b) { let c = a + b; c; }; sum(1, 2) + sum(3, 4);", 10},
+		{"let first = fn() { 1 }; let second = fn() { first() + 1 }; second()", 2},
+		{"let g = 50; let f = fn() { let n = 1; g - n }; f()", 49},
+	})
+}
+
+func TestClosures(t *testing.T) {
+	runVMTests(t, []vmTestCase{
+		{`
+		let newAdder = fn(a, b) {
+			let c = a + b;
+			fn(d) { c + d };
+		};
+		let adder = newAdder(1, 2);
+		adder(8);
+		`, 11},
+		{`
+		let countDown = fn(x) {
+			if (x == 0) { return 0; } else { countDown(x - 1); }
+		};
+		let wrapper = fn() { countDown(1); };
+		wrapper();
+		`, 0},
+		{`
+		let fibonacci = fn(x) {
+			if (x == 0) { return 0; }
+			if (x == 1) { return 1; }
+			fibonacci(x - 1) + fibonacci(x - 2);
+		};
+		fibonacci(15);
+		`, 610},
+	})
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	runVMTests(t, []vmTestCase{
+		{`len("hello")`, 5},
+		{`len([1, 2, 3])`, 3},
+		{`first([1, 2, 3])`, 1},
+		{`last([])`, Null},
+		{`puts("hello")`, Null},
+		{`len(push([], 1))`, 1},
+		{`join(split("a,b", ","), "+")`, "a+b"},
+		{`upper(trim(" a "))`, "A"},
+		{"len(`a\\n` + \"\\t\\\"\\u{e9}\")", 6},
+		{`let n = 2; "${n} + ${n} = ${n + n}"`, "2 + 2 = 4"},
+		{`contains("monkey", "key")`, true},
+		{`format("%d-%s", 1, "a")`, "1-a"},
+		{`len({"a": 1, "b": 2})`, 2},
+		{`has({"a": 1}, "a")`, true},
+		{`if (has({}, 1)) { 1 } else { 2 }`, 2},
+		{`len(keys(merge({"a": 1}, {"b": 2})))`, 2},
+		{`values(delete({"a": 1, "b": 2}, "a"))[0]`, 2},
+		{"reduce(map(filter(range(10), fn(x) { x % 2 == 0 }), fn(x) { x * x }), fn(a, b) { a + b })", 120},
+		{"let f = fn() { let n = 0; map([1, 2, 3], fn(x) { n += x }); n }; f()", 6},
+		{"any([1, 5], fn(x) { x > 4 })", true},
+		{"find([1], fn(x) { x > 4 })", Null},
+		{"sort([3, 1, 2], fn(a, b) { b - a })[0]", 3},
+		{`len(zip(range(3), concat(["a"], reverse(slice(["b", "c", "d"], 1)))))`, 3},
+	})
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+		expectedPos     string
+		expectedStack   []string
+	}{
+		{"5 + true;", "type mismatch: INTEGER + BOOLEAN", "1:1", nil},
+		{"true + false", "unknown operator: BOOLEAN + BOOLEAN", "1:1", nil},
+		{`"a" - "b"`, "unknown operator: STRING - STRING", "1:1", nil},
+		{"-true", "unknown operator: -BOOLEAN", "1:1", nil},
+		{"fn() { 1 }(1)", "wrong number of arguments: want=0, got=1", "1:1", []string{"<anonymous> called at 1:1"}},
+		{"{[fn() {}]: 2}", "unusable as hash key: FUNCTION", "1:1", nil},
+		{"let a = freeze([[1]]); a[0][0] = 2", "cannot modify frozen ARRAY", "1:24", nil},
+		{"1 / 0", "division by zero", "1:1", nil},
+		{"foobar", "identifier not found: foobar", "1:1", nil},
+		{"let f = fn() { 1 + x }; f()", "identifier not found: x", "1:20", []string{"f called at 1:25"}},
+		{"x = 1", "identifier not found: x", "1:1", nil},
+		{"if (false) { let y = 1 }; y", "identifier not found: y", "1:27", nil},
+		{"fn() { if (false) { let y = 1 }; fn() { y } }()()", "identifier not found: y", "1:41", []string{"<anonymous> called at 1:1"}},
+		{"map([1, 2], fn(x) { if (x == 2) { z } else { x } })", "identifier not found: z", "1:35", []string{"<anonymous> called at 1:1", "map called at 1:1"}},
+		{
+			"let f = fn(x) { x + true };\nmap([1], f)",
+			"type mismatch: INTEGER + BOOLEAN", "1:17",
+			[]string{"f called at 2:1", "map called at 2:1"},
+		},
+		{"map([1], fn() { 1 })", "wrong number of arguments: want=0, got=1", "1:1", []string{"<anonymous> called at 1:1", "map called at 1:1"}},
+		{`map(["x"], int)`, `could not parse "x" as integer`, "1:1", []string{"int called at 1:1", "map called at 1:1"}},
+		{"1.5 / 0", "division by zero", "1:1", nil},
+		{
+			"let f = fn(x) {\n  x + true\n};\nf(1)",
+			"type mismatch: INTEGER + BOOLEAN", "2:3",
+			[]string{"f called at 4:1"},
+		},
+		{
+			"let f = fn(x) { len(x) };\nf(1)",
+			"argument to `len` not supported, got INTEGER", "1:17",
+			[]string{"len called at 1:17", "f called at 2:1"},
+		},
+	}
+
+	for _, tt := range tests {
+		vm := New(compile(t, tt.input))
+		err := vm.Run()
+		require.Error(t, err, "expected VM error for %q", tt.input)
+
+		errObj, ok := err.(*object.Error)
+		require.True(t, ok, "error is not *object.Error. got=%T", err)
+		assert.Equal(t, tt.expectedMessage, errObj.Message)
+		assert.Equal(t, tt.expectedPos, errObj.Pos.String())
+
+		var stack []string
+		for _, frame := range errObj.Stack {
+			stack = append(stack, frame.String())
+		}
+		assert.Equal(t, tt.expectedStack, stack)
+	}
+}
+
+func TestInspectCyclic(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let a = [1]; a[0] = a; a", "[[...]]"},
+		{`let h = {}; h["s"] = h; h`, "{s: {...}}"},
+		{`let a = [1]; let h = {"a": a}; a[0] = h; [a, h]`, "[[{a: [...]}], {a: [{...}]}]"},
+		{`let a = [1]; a[0] = a; format("%v", a)`, "[[...]]"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, run(tt.input).Inspect(), "wrong result of %s", tt.input)
+	}
+}
+
+func TestLoopClosures(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let i = 0; let r = []; while (i < 3) { const c = i; r = push(r, fn() { c }); i += 1 }; map(r, fn(f) { f() })", "[0, 1, 2]"},
+		{"let r = []; for (x in [1, 2, 3]) { r = push(r, fn() { x }); }; map(r, fn(f) { f() })", "[1, 2, 3]"},
+		{"let r = []; for (x in [1, 2]) { const c = x * 10; r = push(r, fn() { [x, c] }); }; map(r, fn(f) { f() })", "[[1, 10], [2, 20]]"},
+		{"let r = []; for (x in [1, 2]) { r = push(r, fn() { x }); x += 5; }; map(r, fn(f) { f() })", "[6, 7]"},
+		{"let r = []; let f = fn() { for (x in [1, 2]) { const c = x; r = push(r, fn() { c }); } }; f(); map(r, fn(g) { g() })", "[1, 2]"},
+		{"let r = []; let f = fn() { let i = 0; while (i < 2) { const c = i; r = push(r, fn() { fn() { c } }); i += 1 } }; f(); map(r, fn(g) { g()() })", "[0, 1]"},
+		{"let r = []; for (x in [1, 2]) { const g = fn(n) { if (n == 0) { x } else { g(n - 1) } }; r = push(r, g); }; map(r, fn(g) { g(3) })", "[1, 2]"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, run(tt.input).Inspect(), "wrong result of %s", tt.input)
+	}
+}
+
+func TestCheckedArithmetic(t *testing.T) {
+	runVMTests(t, []vmTestCase{{"9223372036854775807 + 1", bigInt("9223372036854775808")}})
+
+	vm := New(compile(t, "9223372036854775806 + 1"))
+	vm.CheckedArithmetic = true
+	require.NoError(t, vm.Run())
+	testExpectedObject(t, "9223372036854775806 + 1", 9223372036854775807, vm.LastPoppedStackElem())
+
+	vm = New(compile(t, "let f = fn(x) { x * 2 };\nf(4611686018427387904)"))
+	vm.CheckedArithmetic = true
+	err := vm.Run()
+	require.Error(t, err)
+	assert.Equal(t, "ERROR: 1:17: integer overflow: 4611686018427387904 * 2", err.(*object.Error).Inspect())
+
+	vm = New(compile(t, "let m = -9223372036854775807 - 1; -m"))
+	vm.CheckedArithmetic = true
+	err = vm.Run()
+	require.Error(t, err)
+	assert.Equal(t, "integer overflow: -(-9223372036854775808)", err.(*object.Error).Message)
+}
+
+func TestTailCalls(t *testing.T) {
+	runVMTests(t, []vmTestCase{
+		{`let countDown = fn(n) { if (n == 0) { 0 } else { countDown(n - 1) } };
+		countDown(1000000);`, 0},
+		{`let sum = fn(n, acc) { if (n == 0) { return acc; } return sum(n - 1, acc + n); };
+		sum(100000, 0);`, 5000050000},
+		{`let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+		let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+		isEven(100001)`, false},
+		{`let count = fn(n) { if (n == 0) { 0 } else { count(n - 1) } };
+		len(map(range(3), fn(x) { count(100000) }))`, 3},
+		{`let counter = fn(n, f) { if (n == 0) { f() } else { let m = n; counter(n - 1, fn() { m }) } };
+		counter(100000, fn() { 0 })`, 1},
+		{"let f = fn(xs) { len(xs) }; f([1, 2])", 2},
+		{"let f = fn() { for (x in [1, 2]) { return g(x) } }; let g = fn(x) { x * 10 }; f()", 10},
+	})
+
+	vm := New(compile(t, "let f = fn(a) { a }; let g = fn() { f(1, 2) }; g()"))
+	err := vm.Run()
+	require.Error(t, err)
+	assert.Equal(t, "wrong number of arguments: want=1, got=2", err.(*object.Error).Message)
+}
+
+func TestMaxCallDepth(t *testing.T) {
+	input := `let sum = fn(n) { if (n == 0) { 0 } else { n + sum(n - 1) } };`
+	vm := New(compile(t, input+"sum(99)"))
+	vm.MaxCallDepth = 100
+	require.NoError(t, vm.Run())
+	testExpectedObject(t, input+"sum(99)", 4950, vm.LastPoppedStackElem())
+
+	vm = New(compile(t, input+"sum(100)"))
+	vm.MaxCallDepth = 100
+	err := vm.Run()
+	require.Error(t, err)
+	errObj, ok := err.(*object.Error)
+	require.True(t, ok, "error is not *object.Error. got=%T", err)
+	assert.Equal(t, "maximum recursion depth exceeded calling sum", errObj.Message)
+	require.Len(t, errObj.Stack, 101)
+	assert.Equal(t, "sum called at 1:48", errObj.Stack[0].String())
+}
+
+func TestManyLocals(t *testing.T) {
+	// The function uses all 256 local slots, the last one holds 255.
+	var body strings.Builder
+	for i := 0; i < 256; i++ {
+		name := strings.Map(func(r rune) rune { return r - '0' + 'a' }, fmt.Sprint(i))
+		fmt.Fprintf(&body, "let v%s = %d; ", name, i)
+	}
+	runVMTests(t, []vmTestCase{{"let f = fn() { " + body.String() + "vcff }; f()", 255}})
+}
+
+func TestStackGrowth(t *testing.T) {
+	input := `let sum = fn(n) { if (n == 0) { 0 } else { n + sum(n - 1) } }; sum(5000)`
+	runVMTests(t, []vmTestCase{{input, 12502500}})
+}
+
+// TestEngineParity runs the same programs through the evaluator and the VM
+// and expects identical results.
+func TestEngineParity(t *testing.T) {
+	inputs := []string{
+		"1 + 2 * 3 - 4 / 2",
+		"1.5 * 2 + 1 / 4.0",
+		"!(1 > 2) == true",
+		`"Hello" + " " + "World!"`,
+		`let a = [1, "two", fn(x) { x }]; a[2](a[0])`,
+		`{"one": [1, 2]}`,
+		`let m = {"k": [1, 2]}; m["k"][1]`,
+		"if (10 > 1) { if (10 > 1) { return 10; } return 1; }",
+		"let add = fn(a) { fn(b) { a + b } }; add(2)(3)",
+		"let map = fn(arr, f) { if (len(arr) == 0) { [] } else { push(map(rest(arr), f), f(first(arr))) } }; map([1, 2, 3], fn(x) { x * 2 })",
+		"5 + true; 5",
+		"foo == bar",
+		`len(1)`,
+		"let f = fn(a, b) { a }; f(1)",
+		`{fn(x) { x }: 1}`,
+		"[1, 2][true]",
+		"let s = 0; for (x in [1, 2, 3]) { if (x > 2) { break; } let s = s + x; } s",
+		"for (x in 5) { x }",
+		"for (x in [1, 2]) { let y = x; } [y, x]",
+		"let x = 5; for (x in [1, 2]) { x += 10; } x",
+		"let f = fn() { let x = 5; for (x in [1, 2]) { } x }; f()",
+		"let i = 0; while (i < 2) { const y = i; i += 1; } [i, y]",
+		"for (x in [1, 2]) { const y = x; } y",
+		"let f = fn() { for (x in [1, 2]) { const y = x * 10; } y }; f()",
+		"let fs = []; for (x in [1, 2]) { let fs = push(fs, fn() { x }); } fs[0]()",
+		"let f = fn(n) { 1 + f(n + 1) }; f(0)",
+		"let f = fn(n) { 1 + fn(m) { f(m) }(n + 1) }; f(0)",
+		"let i = 0; let r = []; while (i < 3) { const c = i; r = push(r, fn() { c }); i += 1 }; map(r, fn(f) { f() })",
+		"const y = 1; for (x in [1, 2]) { const y = x; }",
+		"let y = 1; for (x in [1, 2]) { const y = x; } y",
+		"let i = 0; while (i < 2) { const c = i; i += 1; let f = fn() { c }; } f()",
+		"let f = fn() { let c = 0; let g = fn() { c += 1 }; g(); c += 10; g() }; f()",
+		"let a = [1]; a[1] = 2",
+		"let a = [1]; a[0] = a; let h = {}; h[a] = 1",
+		"let a = [1]; a[0] = a; freeze(a)",
+		`let s = "a"; s[0] = "b"`,
+		`let h = {"a": 1}; h["a"] += "x"`,
+		"true && 1 + true",
+		"1 % 0",
+		"2 ** -1",
+		"1 << -1",
+		"1.5 & 1",
+		"true <= false",
+		`"a" * "b"`,
+		`let s = "Hello, World"; [s[0], s[100], s[1:5], s[:5], s[7:], s[-5:], s[:]]`,
+		`"abc"[1:"x"]`,
+		`let s = "日本語"; [len(s), s[2], s[:2], s[-1:], indexOf(s, "語"), substr(s, 1, 1), bytes(s[0])]`,
+		"let π = 3.14; π * 2",
+		`{}[:]`,
+		`[split("a b", " "), lower("A"), startsWith("ab", "a"), endsWith("ab", "a"), replace("aa", "a", "b"), indexOf("ab", "b"), substr("abc", 1, 1), repeat("-", 3)]`,
+		`format("%5.1f|%v|%s", 2.25, {"a": [1]}, true)`,
+		`format("%d|%s", 1)`,
+		`format("%d", "abc")`,
+		`join([1], "")`,
+		`let xs = range(1, 6); [map(xs, fn(x) { x * x }), filter(xs, fn(x) { x > 2 }), reduce(xs, fn(a, b) { a * b }), find(xs, fn(x) { x > 3 }), all(xs, fn(x) { x > 0 })]`,
+		`sort(["b", "a", "c"], fn(x, y) { if (x < y) { 1 } else { -1 } })`,
+		"let f = fn(x) { if (x > 1) { 1 + x + true } else { 0 } }; map([1, 2], f)",
+		"sort([2, 1], fn(a, b) { [] })",
+		"let outer = fn(xs) { map(xs, fn(x) { map(x, fn(y) { y * 10 }) }) }; outer([[1], [2, 3]])",
+		`let h = {"b": 1, "a": [2]}; [keys(h), values(h), entries(h), delete(h, "b"), merge(h, {"c": 3}), h]`,
+		`has({}, fn() {})`,
+		`merge({}, 1)`,
+		`let h = {"z": 1, "a": [2], 3: "c"}; h["m"] = 4; h`,
+		`let h = {}; h[[1, "a"]] = 2; h[freeze([1, "a"])]`,
+		`let h = freeze({"a": 1}); h["b"] = 2`,
+		`[1, "a", {"k": [2]}] == [1, "a", {"k": [2.0]}]`,
+		`[1] == 1`,
+		"false || [1][0]",
+		"if (false) { foo }",
+		"let f = fn() { g() }; let g = fn() { 1 }; f()",
+		"let f = fn() { g = 2 }; let g = 1; f(); g",
+		"let f = fn() { puts(y); let y = 1 }; f()",
+		"if (false) { let y = 1 }; y",
+		"map([1, 2], fn(x) { if (x == 2) { z } else { x } })",
+		"undeclared = 1",
+		"[0xFFFF_FFFF_FFFF_FFFF + 1, 0b1111 & 0o17, 1_000.25 * 4]",
+		"[012, 0_17, 07777777777777777777777]",
+		`let xs = [1, 2]; "xs: ${xs}, first: ${xs[0] * 1.5}, ${{"k": "v"}} ${"in ${xs[1]}"}"`,
+		`"${if (false) { 1 }}${true}"`,
+		`"a ${1 + "b"} c"`,
+		"`multi\nline` + \"\\t\\\"\\u{1F600}\\\\\"",
+		"let f = fn() { let x = if (true) { return 7 }; 5 }; f()",
+		"let g = fn() { 1 }; let f = fn() { let x = if (true) { return g(); }; puts(x); 5 }; f()",
+		"let g = fn() { 1 }; [if (true) { return g() }]",
+		"let f = fn(n) { if (n == 0) { n + true } else { f(n - 1) } }; f(100000)",
+		"let countDown = fn(n) { if (n == 0) { 0 } else { countDown(n - 1) } }; countDown(1000000)",
+	}
+
+	for _, input := range inputs {
+		env := object.NewEnvironment()
+		expected := evaluator.Eval(parseProgram(input), env)
+
+		got := run(input)
+		assert.Equal(t, expected.Inspect(), got.Inspect(), "input: %s", input)
+	}
+}
+
+type vmTestCase struct {
+	input    string
+	expected interface{}
+}
+
+func runVMTests(t *testing.T, tests []vmTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		vm := New(compile(t, tt.input))
+		err := vm.Run()
+		require.NoError(t, err, "vm error for %q", tt.input)
+
+		testExpectedObject(t, tt.input, tt.expected, vm.LastPoppedStackElem())
+	}
+}
+
+func testExpectedObject(t *testing.T, input string, expected interface{}, actual object.Object) {
+	t.Helper()
+
+	switch expected := expected.(type) {
+	case int:
+		integer, ok := actual.(*object.Integer)
+		require.True(t, ok, "%q: object is not Integer. got=%T (%+v)", input, actual, actual)
+		assert.Equal(t, int64(expected), integer.Value, input)
+	case bool:
+		boolean, ok := actual.(*object.Boolean)
+		require.True(t, ok, "%q: object is not Boolean. got=%T (%+v)", input, actual, actual)
+		assert.Equal(t, expected, boolean.Value, input)
+	case float64:
+		float, ok := actual.(*object.Float)
+		require.True(t, ok, "%q: object is not Float. got=%T (%+v)", input, actual, actual)
+		assert.Equal(t, expected, float.Value, input)
+	case string:
+		str, ok := actual.(*object.String)
+		require.True(t, ok, "%q: object is not String. got=%T (%+v)", input, actual, actual)
+		assert.Equal(t, expected, str.Value, input)
+	case *big.Int:
+		integer, ok := actual.(*object.BigInteger)
+		require.True(t, ok, "%q: object is not BigInteger. got=%T (%+v)", input, actual, actual)
+		assert.Equal(t, expected.String(), integer.Value.String(), input)
+	case *object.Null:
+		assert.Equal(t, Null, actual, input)
+	}
+}
+
+func bigInt(s string) *big.Int {
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic("invalid big integer " + s)
+	}
+	return v
+}
+
+func parseProgram(input string) *ast.Program {
+	return parser.New(lexer.New(input)).ParseProgram()
+}
+
+func compile(t *testing.T, input string) *compiler.Bytecode {
+	t.Helper()
+
+	comp := compiler.New()
+	err := comp.Compile(parseProgram(input))
+	require.NoError(t, err, "compiler error for %q", input)
+	return comp.Bytecode()
+}
+
+// run executes input the way the REPL's VM engine does: compile errors and
+// runtime errors are both reported as *object.Error values.
+func run(input string) object.Object {
+	comp := compiler.New()
+	if err := comp.Compile(parseProgram(input)); err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+
+	vm := New(comp.Bytecode())
+	if err := vm.Run(); err != nil {
+		if errObj, ok := err.(*object.Error); ok {
+			return errObj
+		}
+		return &object.Error{Message: err.Error()}
+	}
+	return vm.LastPoppedStackElem()
+}